@@ -15,6 +15,7 @@ import (
 	"github.com/appc/spec/aci"
 
 	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/peterbourgon/diskv"
+	pio "github.com/coreos/rocket/pkg/io"
 )
 
 // TODO(philips): use a database for the secondary indexes like remoteType and
@@ -132,8 +133,7 @@ func (ds Store) WriteACI(r io.Reader) (string, error) {
 
 	// Write the decompressed image (tar) to a temporary file on disk, and
 	// tee so we can generate the hash
-	h := sha512.New()
-	tr := io.TeeReader(dr, h)
+	tr := pio.NewHashingReader(dr, sha512.New())
 	fh, err := ds.tmpFile()
 	if err != nil {
 		return "", fmt.Errorf("error creating image: %v", err)
@@ -146,7 +146,7 @@ func (ds Store) WriteACI(r io.Reader) (string, error) {
 	}
 
 	// Import the uncompressed image into the store at the real key
-	key := HashToKey(h)
+	key := HashToKey(tr.H)
 	if err = ds.stores[blobType].Import(fh.Name(), key, true); err != nil {
 		return "", fmt.Errorf("error importing image: %v", err)
 	}
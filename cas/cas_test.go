@@ -63,7 +63,7 @@ func TestDownloading(t *testing.T) {
 			panic("expected a hit got a miss")
 		}
 		ds.stores[remoteType].Write(tt.r.Hash(), tt.r.Marshal())
-		_, err = tt.r.Download(*ds)
+		_, err = tt.r.Download(*ds, DownloadOptions{})
 		if err != nil {
 			panic(err)
 		}
@@ -3,12 +3,12 @@ package cas
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/appc/spec/schema/types"
-	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/mitchellh/ioprogress"
+	pio "github.com/coreos/rocket/pkg/io"
 )
 
 func NewRemote(name string, mirrors []string) *Remote {
@@ -46,39 +46,37 @@ func (r Remote) Type() int64 {
 	return remoteType
 }
 
+// DownloadOptions tweak how Download fetches an image
+type DownloadOptions struct {
+	// LimitRate, if positive, limits the download to that many bytes per
+	// second
+	LimitRate int64
+	// Progress, if not nil, receives the progress of the download every
+	// second
+	Progress pio.ProgressFunc
+}
+
 // TODO: add locking
-func (r Remote) Download(ds Store) (*Remote, error) {
+func (r Remote) Download(ds Store, opts DownloadOptions) (*Remote, error) {
 	res, err := http.Get(r.Name)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
-	prefix := "Downloading aci"
-	fmtBytesSize := 18
-	barSize := int64(80 - len(prefix) - fmtBytesSize)
-	bar := ioprogress.DrawTextFormatBar(barSize)
-	fmtfunc := func(progress, total int64) string {
-		return fmt.Sprintf(
-			"%s: %s %s",
-			prefix,
-			bar(progress, total),
-			ioprogress.DrawTextFormatBytes(progress, total),
-		)
-	}
-
-	reader := &ioprogress.Reader{
-		Reader:       res.Body,
-		Size:         res.ContentLength,
-		DrawFunc:     ioprogress.DrawTerminalf(os.Stdout, fmtfunc),
-		DrawInterval: time.Second,
-	}
-
 	// TODO(jonboulle): handle http more robustly (redirects?)
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("bad HTTP status code: %d", res.StatusCode)
 	}
 
+	var reader io.Reader = res.Body
+	if opts.LimitRate > 0 {
+		reader = pio.NewRateLimitedReader(reader, opts.LimitRate)
+	}
+	if opts.Progress != nil {
+		reader = pio.NewProgressReader(reader, r.Name, res.ContentLength, time.Second, opts.Progress)
+	}
+
 	key, err := ds.WriteACI(reader)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+// The runtime info endpoints describe an app as stage1 runs it, derived from
+// the registered manifests. They answer in JSON unless the client prefers
+// text/plain, in which case they answer with one entry per line, like the
+// other endpoints.
+
+// appMount is a mount point of an app along with the volume fulfilling it
+type appMount struct {
+	Name     types.ACName  `json:"name"`
+	Path     string        `json:"path"`
+	ReadOnly bool          `json:"readOnly"`
+	Volume   *types.Volume `json:"volume,omitempty"` // nil if no volume fulfills the mount point
+}
+
+// wantsText tells whether the client of r prefers text/plain over JSON,
+// according to its Accept header
+func wantsText(r *http.Request) bool {
+	qs := map[string]float64{}
+	for _, mr := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, params, err := mime.ParseMediaType(strings.TrimSpace(mr))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > qs[t] {
+			qs[t] = q
+		}
+	}
+	return qs["text/plain"] > qs["application/json"]
+}
+
+// writeInfo answers r with v in JSON, or with lines if the client prefers
+// text
+func writeInfo(w http.ResponseWriter, r *http.Request, v interface{}, lines []string) {
+	if wantsText(r) {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		for _, l := range lines {
+			fmt.Fprintln(w, l)
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// appEnv returns the environment the app runs with
+func appEnv(am *schema.ImageManifest) map[string]string {
+	env := make(map[string]string)
+	for k, v := range am.App.Environment {
+		env[k] = v
+	}
+	env["AC_APP_NAME"] = am.Name.String()
+	return env
+}
+
+// appMounts resolves the mount points of the app against the volumes of the
+// container
+func appMounts(am *schema.ImageManifest, cm *schema.ContainerRuntimeManifest) []appMount {
+	vols := make(map[types.ACName]types.Volume)
+	for _, v := range cm.Volumes {
+		for _, f := range v.Fulfills {
+			vols[f] = v
+		}
+	}
+
+	mounts := []appMount{}
+	for _, mp := range am.App.MountPoints {
+		mt := appMount{Name: mp.Name, Path: mp.Path, ReadOnly: mp.ReadOnly}
+		if v, ok := vols[mp.Name]; ok {
+			mt.Volume = &v
+		}
+		mounts = append(mounts, mt)
+	}
+	return mounts
+}
+
+func handleAppEnv(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.ImageManifest) {
+	env := appEnv(am)
+	var lines []string
+	for k, v := range env {
+		lines = append(lines, k+"="+v)
+	}
+	sort.Strings(lines)
+	writeInfo(w, r, env, lines)
+}
+
+func handleAppMounts(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.ImageManifest) {
+	mounts := appMounts(am, &m.manifest)
+	var lines []string
+	for _, mt := range mounts {
+		mode, src := "rw", "-"
+		if mt.ReadOnly {
+			mode = "ro"
+		}
+		if mt.Volume != nil {
+			src = mt.Volume.Source
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", mt.Name, mt.Path, src, mode))
+	}
+	writeInfo(w, r, mounts, lines)
+}
+
+func handleAppPorts(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.ImageManifest) {
+	ports := am.App.Ports
+	if ports == nil {
+		ports = []types.Port{}
+	}
+	var lines []string
+	for _, p := range ports {
+		lines = append(lines, fmt.Sprintf("%s %s %d", p.Name, p.Protocol, p.Port))
+	}
+	writeInfo(w, r, ports, lines)
+}
+
+func handleContainerAddresses(w http.ResponseWriter, r *http.Request, m *metadata) {
+	writeInfo(w, r, m.ips, m.ips)
+}
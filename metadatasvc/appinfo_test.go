@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestWantsText(t *testing.T) {
+	tests := []struct {
+		accept string
+		text   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/plain", true},
+		{"text/plain; charset=utf-8", true},
+		{"application/json, text/plain", false},
+		{"application/json;q=0.5, text/plain", true},
+		{"text/plain;q=0.1, application/json;q=0.2", false},
+		{"text/html, text/plain;q=0.9", true},
+		{"text/plain;q=bogus", false},
+	}
+	for i, tt := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := wantsText(r); got != tt.text {
+			t.Errorf("#%d: %q: got %v, wanted %v", i, tt.accept, got, tt.text)
+		}
+	}
+}
+
+func TestAppInfo(t *testing.T) {
+	setupStore(t, "")
+
+	uid, err := types.NewUUID(testUUID)
+	if err != nil {
+		t.Fatalf("error parsing UUID: %v", err)
+	}
+	cm, err := json.Marshal(schema.ContainerRuntimeManifest{
+		ACKind: "ContainerRuntimeManifest",
+		UUID:   *uid,
+		Apps:   schema.AppList{{Name: "example.com/app"}},
+		Volumes: []types.Volume{
+			{Kind: "host", Source: "/srv/data", Fulfills: []types.ACName{"data"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	am, err := json.Marshal(schema.ImageManifest{
+		Name: "example.com/app",
+		App: types.App{
+			Environment: map[string]string{"PORT": "8080"},
+			MountPoints: []types.MountPoint{
+				{Name: "data", Path: "/var/data"},
+				{Name: "cache", Path: "/var/cache", ReadOnly: true},
+			},
+			Ports: []types.Port{{Name: "http", Protocol: "tcp", Port: 8080}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	if w := doReg("POST", "/containers/?container_brport=veth0&container_ip=10.0.0.2&container_ip=fd00::2", cm); w.Code != http.StatusOK {
+		t.Fatalf("error registering container: %d %s", w.Code, w.Body)
+	}
+	if w := doReg("PUT", "/containers/"+testUUID+"/example.com/app", am); w.Code != http.StatusOK {
+		t.Fatalf("error registering app: %d %s", w.Code, w.Body)
+	}
+
+	getInfo := func(path, accept string) (string, string) {
+		req, err := http.NewRequest("GET", "/acMetadata/v1"+path, nil)
+		if err != nil {
+			t.Fatalf("error creating request: %v", err)
+		}
+		req.RemoteAddr = "10.0.0.2:1234"
+		req.Header.Set("Metadata-Flavor", "AppContainer header")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, wanted %d", path, w.Code, http.StatusOK)
+		}
+		return w.Header().Get("Content-Type"), w.Body.String()
+	}
+
+	var env map[string]string
+	ct, body := getInfo("/apps/example.com/app/env", "")
+	if ct != "application/json" {
+		t.Errorf("env: got content type %q", ct)
+	}
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		t.Fatalf("error decoding env: %v", err)
+	}
+	wenv := map[string]string{"PORT": "8080", "AC_APP_NAME": "example.com/app"}
+	if !reflect.DeepEqual(env, wenv) {
+		t.Errorf("env: got %v, wanted %v", env, wenv)
+	}
+
+	var mounts []appMount
+	_, body = getInfo("/apps/example.com/app/mounts", "application/json")
+	if err := json.Unmarshal([]byte(body), &mounts); err != nil {
+		t.Fatalf("error decoding mounts: %v", err)
+	}
+	if len(mounts) != 2 || mounts[0].Volume == nil || mounts[0].Volume.Source != "/srv/data" || mounts[1].Volume != nil {
+		t.Errorf("mounts: unexpected %+v", mounts)
+	}
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/apps/example.com/app/env", "AC_APP_NAME=example.com/app\nPORT=8080\n"},
+		{"/apps/example.com/app/mounts", "data /var/data /srv/data rw\ncache /var/cache - ro\n"},
+		{"/apps/example.com/app/ports", "http tcp 8080\n"},
+		{"/container/addresses", "10.0.0.2\nfd00::2\n"},
+	}
+	for _, tt := range tests {
+		ct, body := getInfo(tt.path, "text/plain")
+		if ct != "text/plain" {
+			t.Errorf("%s: got content type %q", tt.path, ct)
+		}
+		if body != tt.body {
+			t.Errorf("%s: got %q, wanted %q", tt.path, body, tt.body)
+		}
+	}
+
+	_, body = getInfo("/container/addresses", "")
+	if body != "[\"10.0.0.2\",\"fd00::2\"]\n" {
+		t.Errorf("addresses: got %q", body)
+	}
+}
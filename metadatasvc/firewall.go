@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// firewall installs the packet filtering rules the metadata service relies
+// on. Every operation is idempotent, so that rules don't pile up across
+// restarts.
+type firewall interface {
+	// Setup redirects connections to the metadata address to the service
+	Setup() error
+	// AntiSpoof drops IPv4 traffic entering from the bridge port brPort
+	// which does not originate from ip
+	AntiSpoof(brPort, ip string) error
+	// RemoveAntiSpoof undoes AntiSpoof
+	RemoveAntiSpoof(brPort, ip string) error
+	// Teardown removes every rule installed
+	Teardown() error
+}
+
+// fwConfig holds the addresses the firewall redirects
+type fwConfig struct {
+	metaIP    string // IPv4 metadata address
+	metaIP6   string // IPv6 metadata address, if any
+	metaPort  string // port of the metadata addresses
+	redirPort string // port the service listens on
+}
+
+// newFirewall returns the firewall implementation of the given name
+func newFirewall(name string, cfg *fwConfig) (firewall, error) {
+	switch name {
+	case "iptables":
+		return &iptablesFirewall{cfg: cfg, antiSpoofed: make(map[[2]string]bool)}, nil
+	case "nftables":
+		return nftablesFirewall{cfg}, nil
+	case "none":
+		return noopFirewall{cfg}, nil
+	default:
+		return nil, fmt.Errorf("unknown firewall %q", name)
+	}
+}
+
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// iptablesFirewall uses iptables and ip6tables for the redirection and
+// ebtables against spoofing
+type iptablesFirewall struct {
+	cfg *fwConfig
+
+	mu          sync.Mutex
+	antiSpoofed map[[2]string]bool // anti-spoofing rules installed, by bridge port and ip
+}
+
+// redirects returns the iptables commands and the rules redirecting the
+// metadata addresses
+func (f *iptablesFirewall) redirects() map[string][]string {
+	rule := func(ip string) []string {
+		return []string{"PREROUTING", "-p", "tcp", "-d", ip, "--dport", f.cfg.metaPort,
+			"-j", "REDIRECT", "--to-port", f.cfg.redirPort}
+	}
+	rs := map[string][]string{"iptables": rule(f.cfg.metaIP)}
+	if f.cfg.metaIP6 != "" {
+		rs["ip6tables"] = rule(f.cfg.metaIP6)
+	}
+	return rs
+}
+
+func antiSpoofRule(brPort, ip string) []string {
+	if net.ParseIP(ip).To4() == nil {
+		return []string{"INPUT", "-i", brPort, "-p", "IPv6", "!", "--ip6-source", ip, "-j", "DROP"}
+	}
+	return []string{"INPUT", "-i", brPort, "-p", "IPV4", "!", "--ip-source", ip, "-j", "DROP"}
+}
+
+// ebtablesDelete deletes every copy of rule r. ebtables can't check for
+// existing rules, so deleting until it fails is the only way to know the
+// rule is gone.
+func ebtablesDelete(r []string) {
+	for exec.Command("ebtables", append([]string{"-t", "filter", "-D"}, r...)...).Run() == nil {
+	}
+}
+
+func (f *iptablesFirewall) Setup() error {
+	for cmd, r := range f.redirects() {
+		if exec.Command(cmd, append([]string{"-t", "nat", "-C"}, r...)...).Run() == nil {
+			continue
+		}
+		if err := run(cmd, append([]string{"-t", "nat", "-A"}, r...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *iptablesFirewall) AntiSpoof(brPort, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// replace any rule left behind by a previous instance
+	r := antiSpoofRule(brPort, ip)
+	ebtablesDelete(r)
+	if err := run("ebtables", append([]string{"-t", "filter", "-I"}, r...)...); err != nil {
+		return err
+	}
+	f.antiSpoofed[[2]string{brPort, ip}] = true
+	return nil
+}
+
+func (f *iptablesFirewall) RemoveAntiSpoof(brPort, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ebtablesDelete(antiSpoofRule(brPort, ip))
+	delete(f.antiSpoofed, [2]string{brPort, ip})
+	return nil
+}
+
+func (f *iptablesFirewall) Teardown() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for k := range f.antiSpoofed {
+		ebtablesDelete(antiSpoofRule(k[0], k[1]))
+		delete(f.antiSpoofed, k)
+	}
+	for cmd, r := range f.redirects() {
+		for exec.Command(cmd, append([]string{"-t", "nat", "-C"}, r...)...).Run() == nil {
+			if err := run(cmd, append([]string{"-t", "nat", "-D"}, r...)...); err != nil {
+				return fmt.Errorf("failed to remove firewall rules: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// nftablesFirewall keeps its rules in tables of its own, so they are easily
+// told apart from the rest of the ruleset
+type nftablesFirewall struct {
+	cfg *fwConfig
+}
+
+const nftTable = "rkt-metadata"
+
+func nft(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nft: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (f nftablesFirewall) Setup() error {
+	// start from scratch, whatever a previous instance left behind
+	f.Teardown()
+
+	script := fmt.Sprintf(`
+table ip %[1]s {
+	chain prerouting {
+		type nat hook prerouting priority -100;
+		ip daddr %[2]s tcp dport %[3]s redirect to :%[4]s
+	}
+}
+table bridge %[1]s {
+	set ports {
+		type ifname;
+	}
+	set allowed {
+		type ifname . ipv4_addr;
+	}
+	set ports6 {
+		type ifname;
+	}
+	set allowed6 {
+		type ifname . ipv6_addr;
+	}
+	chain input {
+		type filter hook input priority 0;
+		ether type ip iifname . ip saddr @allowed accept
+		ether type ip iifname @ports drop
+		ether type ip6 iifname . ip6 saddr @allowed6 accept
+		ether type ip6 iifname @ports6 drop
+	}
+}
+`, nftTable, f.cfg.metaIP, f.cfg.metaPort, f.cfg.redirPort)
+	if f.cfg.metaIP6 != "" {
+		script += fmt.Sprintf(`
+table ip6 %[1]s {
+	chain prerouting {
+		type nat hook prerouting priority -100;
+		ip6 daddr %[2]s tcp dport %[3]s redirect to :%[4]s
+	}
+}
+`, nftTable, f.cfg.metaIP6, f.cfg.metaPort, f.cfg.redirPort)
+	}
+	return nft(script)
+}
+
+// nftSets returns the sets of the allowed addresses and of the guarded ports
+// for the family of ip
+func nftSets(ip string) (allowed string, ports string) {
+	if net.ParseIP(ip).To4() == nil {
+		return "allowed6", "ports6"
+	}
+	return "allowed", "ports"
+}
+
+func (f nftablesFirewall) AntiSpoof(brPort, ip string) error {
+	allowed, ports := nftSets(ip)
+	return nft(fmt.Sprintf(`
+add element bridge %[1]s %[2]s { "%[4]s" . %[5]s }
+add element bridge %[1]s %[3]s { "%[4]s" }
+`, nftTable, allowed, ports, brPort, ip))
+}
+
+func (f nftablesFirewall) RemoveAntiSpoof(brPort, ip string) error {
+	// deleting missing elements fails, which is fine
+	allowed, ports := nftSets(ip)
+	nft(fmt.Sprintf(`delete element bridge %s %s { "%s" }`, nftTable, ports, brPort))
+	nft(fmt.Sprintf(`delete element bridge %s %s { "%s" . %s }`, nftTable, allowed, brPort, ip))
+	return nil
+}
+
+func (f nftablesFirewall) Teardown() error {
+	var errs []string
+	for _, family := range []string{"ip", "ip6", "bridge"} {
+		if exec.Command("nft", "list", "table", family, nftTable).Run() != nil {
+			continue
+		}
+		if err := run("nft", "delete", "table", family, nftTable); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove firewall rules: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// noopFirewall installs no rules, leaving the host unprotected; it only logs
+// what it would do
+type noopFirewall struct {
+	cfg *fwConfig
+}
+
+func (f noopFirewall) Setup() error {
+	log.Printf("firewall disabled: not redirecting %s:%s to port %s", f.cfg.metaIP, f.cfg.metaPort, f.cfg.redirPort)
+	if f.cfg.metaIP6 != "" {
+		log.Printf("firewall disabled: not redirecting [%s]:%s to port %s", f.cfg.metaIP6, f.cfg.metaPort, f.cfg.redirPort)
+	}
+	return nil
+}
+
+func (f noopFirewall) AntiSpoof(brPort, ip string) error {
+	log.Printf("firewall disabled: not guarding %s against spoofing of %s", brPort, ip)
+	return nil
+}
+
+func (f noopFirewall) RemoveAntiSpoof(brPort, ip string) error {
+	return nil
+}
+
+func (f noopFirewall) Teardown() error {
+	return nil
+}
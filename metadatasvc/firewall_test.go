@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewFirewall(t *testing.T) {
+	cfg := &fwConfig{metaIP: "169.254.169.255", metaIP6: "fe80::a9fe:a9ff", metaPort: "80", redirPort: "4444"}
+	for _, name := range []string{"iptables", "nftables", "none"} {
+		if _, err := newFirewall(name, cfg); err != nil {
+			t.Errorf("unexpected error creating firewall %q: %v", name, err)
+		}
+	}
+	if _, err := newFirewall("ipchains", cfg); err == nil {
+		t.Errorf("expected error creating unknown firewall")
+	}
+
+	f, _ := newFirewall("none", cfg)
+	if err := f.Setup(); err != nil {
+		t.Errorf("unexpected error setting up no-op firewall: %v", err)
+	}
+	if err := f.AntiSpoof("veth0", "10.0.0.2"); err != nil {
+		t.Errorf("unexpected error from no-op firewall: %v", err)
+	}
+	if err := f.AntiSpoof("veth0", "fd00::2"); err != nil {
+		t.Errorf("unexpected error from no-op firewall: %v", err)
+	}
+	if err := f.Teardown(); err != nil {
+		t.Errorf("unexpected error tearing down no-op firewall: %v", err)
+	}
+}
+
+func TestAntiSpoofRule(t *testing.T) {
+	r := antiSpoofRule("veth0", "10.0.0.2")
+	if r[4] != "IPV4" || r[6] != "--ip-source" {
+		t.Errorf("unexpected IPv4 rule: %v", r)
+	}
+	r = antiSpoofRule("veth0", "fd00::2")
+	if r[4] != "IPv6" || r[6] != "--ip6-source" {
+		t.Errorf("unexpected IPv6 rule: %v", r)
+	}
+}
+
+func TestFirewallConfig(t *testing.T) {
+	defer func(l, ip, ip6, port string) {
+		flagListen, flagMetaIP, flagMetaIP6, flagMetaPort = l, ip, ip6, port
+	}(flagListen, flagMetaIP, flagMetaIP6, flagMetaPort)
+
+	tests := []struct {
+		listen, ip, ip6, port string
+		ok                    bool
+	}{
+		{":4444", "169.254.169.255", "fe80::a9fe:a9ff", "80", true},
+		{"127.0.0.1:8080", "169.254.169.254", "", "80", true},
+		{"[::1]:8080", "169.254.169.254", "fe80::1", "8080", true},
+		{"4444", "169.254.169.255", "", "80", false},
+		{":4444", "fe80::1", "", "80", false},
+		{":4444", "169.254.169.255", "fd00::1", "80", false},
+		{":4444", "169.254.169.255", "10.0.0.1", "80", false},
+		{":4444", "169.254.169.255", "", "http", false},
+		{":4444", "169.254.169.255", "", "70000", false},
+	}
+	for i, tt := range tests {
+		flagListen, flagMetaIP, flagMetaIP6, flagMetaPort = tt.listen, tt.ip, tt.ip6, tt.port
+		cfg, err := firewallConfig()
+		if tt.ok != (err == nil) {
+			t.Errorf("#%d: unexpected result: %v", i, err)
+			continue
+		}
+		if err == nil && (cfg.metaIP != tt.ip || cfg.metaIP6 != tt.ip6 || cfg.metaPort != tt.port) {
+			t.Errorf("#%d: unexpected config %+v", i, cfg)
+		}
+	}
+}
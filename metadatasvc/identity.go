@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// identityDocument asserts the identity of a container. It is signed with
+// the identity key of the service, whose public part is published, so that
+// anyone can verify it without contacting the service.
+type identityDocument struct {
+	UUID     string        `json:"uuid"`
+	Apps     []identityApp `json:"apps"`
+	IssuedAt time.Time     `json:"issuedAt"`
+	Expires  time.Time     `json:"expires"`
+}
+
+type identityApp struct {
+	Name    string `json:"name"`
+	ImageID string `json:"imageID"`
+}
+
+// signedIdentity is an identityDocument along with its signature. The
+// document is kept in the exact form that was signed.
+type signedIdentity struct {
+	Document  []byte `json:"document"`  // JSON-encoded identityDocument
+	Algorithm string `json:"algorithm"` // "Ed25519", or "RS256" for RSA PKCS #1 v1.5 with SHA-256
+	KeyID     string `json:"keyID"`     // hex SHA-256 of the PKIX-encoded public key
+	Signature []byte `json:"signature"`
+}
+
+const (
+	algEd25519 = "Ed25519"
+	algRS256   = "RS256"
+)
+
+// idKey signs the identity documents
+var idKey crypto.Signer
+
+// loadIdentityKey returns the private key in the PEM file at path. If path is
+// empty, an Ed25519 key is taken from the state dir, generated on first use,
+// or generated for the lifetime of the service if stateDir is empty too.
+func loadIdentityKey(path, stateDir string) (crypto.Signer, error) {
+	if path == "" {
+		if stateDir == "" {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		}
+		path = filepath.Join(stateDir, "identity.key")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return genIdentityKey(path)
+		}
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity key: %v", err)
+	}
+	b, _ := pem.Decode(buf)
+	if b == nil {
+		return nil, fmt.Errorf("identity key %q is not PEM-encoded", path)
+	}
+
+	var key interface{}
+	switch b.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(b.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(b.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", b.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity key %q: %v", path, err)
+	}
+
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *rsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("identity key %q is neither an Ed25519 nor an RSA key", path)
+	}
+}
+
+func genIdentityKey(path string) (crypto.Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	buf := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := writeFileAtomic(path, buf); err != nil {
+		return nil, fmt.Errorf("failed to save identity key: %v", err)
+	}
+	return key, nil
+}
+
+// keyID returns the identifier of a public key
+func keyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(der)), nil
+}
+
+// signIdentity issues a signed identity document for the container described
+// by m, valid for ttl
+func signIdentity(key crypto.Signer, m *metadata, now time.Time, ttl time.Duration) (*signedIdentity, error) {
+	doc := identityDocument{
+		UUID:     m.manifest.UUID.String(),
+		IssuedAt: now.UTC(),
+		Expires:  now.Add(ttl).UTC(),
+	}
+	for _, ra := range m.manifest.Apps {
+		doc.Apps = append(doc.Apps, identityApp{
+			Name:    ra.Name.String(),
+			ImageID: ra.ImageID.String(),
+		})
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	si := &signedIdentity{Document: buf}
+	if si.KeyID, err = keyID(key.Public()); err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		si.Algorithm = algEd25519
+		si.Signature, err = key.Sign(rand.Reader, buf, crypto.Hash(0))
+	case *rsa.PrivateKey:
+		si.Algorithm = algRS256
+		d := sha256.Sum256(buf)
+		si.Signature, err = key.Sign(rand.Reader, d[:], crypto.SHA256)
+	default:
+		err = errors.New("unsupported identity key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign identity document: %v", err)
+	}
+	return si, nil
+}
+
+// verifyIdentity checks the signature of si against pub and returns the
+// document if it has not expired. It is what a verifier holding the public
+// key of the service does.
+func verifyIdentity(pub crypto.PublicKey, si *signedIdentity, now time.Time) (*identityDocument, error) {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if si.Algorithm != algEd25519 || !ed25519.Verify(pub, si.Document, si.Signature) {
+			return nil, errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		d := sha256.Sum256(si.Document)
+		if si.Algorithm != algRS256 || rsa.VerifyPKCS1v15(pub, crypto.SHA256, d[:], si.Signature) != nil {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		return nil, errors.New("unsupported public key")
+	}
+
+	doc := &identityDocument{}
+	if err := json.Unmarshal(si.Document, doc); err != nil {
+		return nil, fmt.Errorf("malformed identity document: %v", err)
+	}
+	if now.After(doc.Expires) {
+		return nil, errors.New("identity document expired")
+	}
+	return doc, nil
+}
+
+func handleContainerIdentity(w http.ResponseWriter, r *http.Request, m *metadata) {
+	si, err := signIdentity(idKey, m, time.Now(), flagIdentityTTL)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(si); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func handleIdentityPublicKey(w http.ResponseWriter, r *http.Request) {
+	der, err := x509.MarshalPKIXPublicKey(idKey.Public())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to encode public key: %v", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/x-pem-file")
+	w.WriteHeader(http.StatusOK)
+	pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fetchIdentity requests an identity document from ip and verifies it with
+// the published public key
+func fetchIdentity(t *testing.T, ip string, now time.Time) (*identityDocument, error) {
+	w := do("GET", "/acMetadata/v1/container/identity", ip+":1234", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error fetching identity: %d %s", w.Code, w.Body)
+	}
+	si := &signedIdentity{}
+	if err := json.Unmarshal(w.Body.Bytes(), si); err != nil {
+		t.Fatalf("error decoding identity: %v", err)
+	}
+
+	w = do("GET", "/identity/public-key", "192.168.0.1:1234", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error fetching public key: %d %s", w.Code, w.Body)
+	}
+	b, _ := pem.Decode(w.Body.Bytes())
+	if b == nil || b.Type != "PUBLIC KEY" {
+		t.Fatalf("public key is not PEM-encoded: %s", w.Body)
+	}
+	pub, err := x509.ParsePKIXPublicKey(b.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing public key: %v", err)
+	}
+	if id, _ := keyID(pub); id != si.KeyID {
+		t.Errorf("key ID mismatch: %s != %s", id, si.KeyID)
+	}
+
+	return verifyIdentity(pub, si, now)
+}
+
+func TestIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	rsaPath := filepath.Join(dir, "rsa.pem")
+	buf := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	if err := ioutil.WriteFile(rsaPath, buf, 0600); err != nil {
+		t.Fatalf("error writing RSA key: %v", err)
+	}
+
+	setupStore(t, "")
+	mustRegister(t, testUUID, "10.0.0.2")
+
+	for _, path := range []string{"", rsaPath} {
+		if idKey, err = loadIdentityKey(path, ""); err != nil {
+			t.Fatalf("error loading identity key %q: %v", path, err)
+		}
+
+		now := time.Now()
+		doc, err := fetchIdentity(t, "10.0.0.2", now)
+		if err != nil {
+			t.Fatalf("error verifying identity: %v", err)
+		}
+		if doc.UUID != testUUID || len(doc.Apps) != 1 || doc.Apps[0].Name != "example.com/app" {
+			t.Errorf("unexpected identity document: %+v", doc)
+		}
+
+		if _, err := fetchIdentity(t, "10.0.0.2", now.Add(flagIdentityTTL+time.Minute)); err == nil {
+			t.Errorf("expected error verifying expired identity document")
+		}
+	}
+
+	// documents signed by another key are rejected
+	si, err := signIdentity(rsaKey, mds.containers()[0], time.Now(), time.Hour)
+	if err != nil {
+		t.Fatalf("error signing identity: %v", err)
+	}
+	si.Document[len(si.Document)-2] ^= 1
+	if _, err := verifyIdentity(rsaKey.Public(), si, time.Now()); err == nil {
+		t.Errorf("expected error verifying tampered identity document")
+	}
+}
+
+func TestIdentityKeyPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	k1, err := loadIdentityKey("", dir)
+	if err != nil {
+		t.Fatalf("error generating identity key: %v", err)
+	}
+	k2, err := loadIdentityKey("", dir)
+	if err != nil {
+		t.Fatalf("error loading identity key: %v", err)
+	}
+	id1, _ := keyID(k1.Public())
+	id2, _ := keyID(k2.Public())
+	if id1 != id2 {
+		t.Errorf("identity key not persisted")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.pem"), []byte("garbage"), 0600); err != nil {
+		t.Fatalf("error writing key: %v", err)
+	}
+	if _, err := loadIdentityKey(filepath.Join(dir, "bad.pem"), ""); err == nil {
+		t.Errorf("expected error loading garbage key")
+	}
+}
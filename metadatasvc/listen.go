@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// listeners returns the listeners for the registration API and for the
+// metadata API. They are taken from systemd if the service is socket
+// activated, and created otherwise.
+func listeners() (regL net.Listener, mdL net.Listener, err error) {
+	ls, err := activatedListeners()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, l := range ls {
+		switch l.Addr().Network() {
+		case "unix":
+			regL = l
+		case "tcp", "tcp4", "tcp6":
+			mdL = l
+		default:
+			return nil, nil, fmt.Errorf("unexpected socket passed by systemd: %v", l.Addr())
+		}
+	}
+
+	if regL == nil {
+		if regL, err = listenUnix(flagRegSocket); err != nil {
+			return nil, nil, err
+		}
+	}
+	if mdL == nil {
+		if mdL, err = net.Listen("tcp", flagListen); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if flagCheckPeer {
+		regL = &peerCredListener{regL, 0}
+	}
+	return regL, mdL, nil
+}
+
+// activatedListeners returns the sockets passed by systemd socket
+// activation, see sd_listen_fds(3)
+func activatedListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %v", err)
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+
+	// the passed descriptors start right after stderr
+	const listenFdsStart = 3
+	var ls []net.Listener
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use socket passed by systemd: %v", err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// listenUnix listens on a Unix socket at path, accessible to its owner only
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket dir: %v", err)
+	}
+	// the socket is left behind by a previous instance
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+
+	// create the socket with the right permissions rather than fixing them
+	// afterwards, to leave no window for others to connect
+	mask := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// peerCredListener only accepts connections from processes running as the
+// given user
+type peerCredListener struct {
+	net.Listener
+	uid uint32
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(c)
+		if err == nil && uid == l.uid {
+			return c, nil
+		}
+		if err != nil {
+			log.Printf("rejecting registration connection: %v", err)
+		} else {
+			log.Printf("rejecting registration connection from uid %d", uid)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistrationSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	setupStore(t, "")
+
+	sock := filepath.Join(dir, "run", "mds.sock")
+	// a stale socket is replaced
+	if err := os.MkdirAll(filepath.Dir(sock), 0755); err != nil {
+		t.Fatalf("error creating socket dir: %v", err)
+	}
+	if err := ioutil.WriteFile(sock, nil, 0644); err != nil {
+		t.Fatalf("error creating stale socket: %v", err)
+	}
+
+	l, err := listenUnix(sock)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("error checking socket: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("unexpected socket permissions: %v, wanted 0600", perm)
+	}
+
+	// accept connections from our own user only
+	go http.Serve(&peerCredListener{l, uint32(os.Getuid())}, newRegistrationRouter())
+	if code, err := post(sock); err != nil || code != http.StatusBadRequest {
+		t.Errorf("got status %d (%v), wanted %d", code, err, http.StatusBadRequest)
+	}
+
+	// connections from users other than the expected one are rejected
+	other := filepath.Join(dir, "run", "other.sock")
+	ol, err := listenUnix(other)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ol.Close()
+	go http.Serve(&peerCredListener{ol, uint32(os.Getuid()) + 1}, newRegistrationRouter())
+	if _, err := post(other); err == nil {
+		t.Errorf("request from unexpected user accepted")
+	}
+}
+
+// post sends an incomplete registration request over the socket at path
+func post(path string) (int, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(string, string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Post("http://metadatasvc/containers/?container_ip=10.0.0.2", "application/json", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -2,51 +2,61 @@ package main
 
 import (
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
-	"os/exec"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/appc/spec/schema"
 	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/gorilla/mux"
 )
 
-type metadata struct {
-	manifest schema.ContainerRuntimeManifest
-	apps     map[string]*schema.ImageManifest
-}
-
 var (
-	metadataByIP  = make(map[string]*metadata)
-	metadataByUID = make(map[types.UUID]*metadata)
-	hmacKey       [sha256.Size]byte
+	mds *store
+	fw  firewall
+
+	flagStateDir     string
+	flagRktDir       string
+	flagReapInterval time.Duration
+	flagRegSocket    string
+	flagCheckPeer    bool
+	flagFirewall     string
+	flagIdentityKey  string
+	flagIdentityTTL  time.Duration
+	flagListen       string
+	flagMetaIP       string
+	flagMetaIP6      string
+	flagMetaPort     string
 )
 
-const (
-	myPort   = "4444"
-	metaIP   = "169.254.169.255"
-	metaPort = "80"
-)
-
-func setupIPTables() error {
-	args := []string{"-t", "nat", "-A", "PREROUTING",
-		"-p", "tcp", "-d", metaIP, "--dport", metaPort,
-		"-j", "REDIRECT", "--to-port", myPort}
-
-	return exec.Command("iptables", args...).Run()
-}
-
-func antiSpoof(brPort, ipAddr string) error {
-	args := []string{"-t", "filter", "-I", "INPUT", "-i", brPort, "-p", "IPV4", "!", "--ip-source", ipAddr, "-j", "DROP"}
-	return exec.Command("ebtables", args...).Run()
+func init() {
+	flag.StringVar(&flagListen, "listen", ":4444", "address to serve the metadata API on")
+	flag.StringVar(&flagMetaIP, "metadata-ip", "169.254.169.255", "IPv4 address containers reach the metadata service at")
+	flag.StringVar(&flagMetaIP6, "metadata-ip6", "", "IPv6 link-local address containers reach the metadata service at, which must be assigned to their bridge; if unset, IPv6 is disabled")
+	flag.StringVar(&flagMetaPort, "metadata-port", "80", "port containers reach the metadata service on")
+	flag.StringVar(&flagStateDir, "state-dir", "", "directory to persist registrations and the HMAC key in; if unset, they are lost on exit")
+	flag.StringVar(&flagRktDir, "rkt-dir", "/var/lib/rkt", "rocket data directory, to find the containers in")
+	flag.StringVar(&flagRegSocket, "registration-socket", "/run/rkt/metadata-svc.sock", "path of the Unix socket to serve the registration API on, accessible to root only")
+	flag.BoolVar(&flagCheckPeer, "check-peer", true, "only accept registrations from processes running as root, according to their peer credentials")
+	flag.StringVar(&flagFirewall, "firewall", "iptables", "packet filter to set up: iptables (with ebtables), nftables or none")
+	flag.StringVar(&flagIdentityKey, "identity-key", "", "PEM-encoded Ed25519 or RSA private key to sign identity documents with; if unset, an Ed25519 key is generated (and kept in the state dir)")
+	flag.DurationVar(&flagIdentityTTL, "identity-ttl", time.Hour, "validity of the identity documents issued")
+	flag.DurationVar(&flagReapInterval, "reap-interval", 0, "interval at which registrations of containers that are no longer running are expired; 0 disables")
 }
 
 func queryValue(u *url.URL, key string) string {
@@ -57,57 +67,115 @@ func queryValue(u *url.URL, key string) string {
 	return vals[0]
 }
 
-func handleRegisterContainer(w http.ResponseWriter, r *http.Request) {
-	remoteIP := strings.Split(r.RemoteAddr, ":")[0]
-	if _, ok := metadataByIP[remoteIP]; ok {
-		// not allowed from container IP
-		w.WriteHeader(http.StatusForbidden)
-		return
+// remoteIP returns the IP address of the client of r
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// containerIPs parses the addresses of a container being registered: at most
+// one IPv4 and one IPv6 address
+func containerIPs(vals []string) ([]string, error) {
+	if len(vals) == 0 {
+		return nil, errors.New("container_ip missing")
+	}
+	var ips []string
+	var v4, v6 bool
+	for _, v := range vals {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("container_ip %q is not an IP address", v)
+		}
+		is4 := ip.To4() != nil
+		if (is4 && v4) || (!is4 && v6) {
+			return nil, errors.New("container_ip given more than once for the same address family")
+		}
+		v4, v6 = v4 || is4, v6 || !is4
+		ips = append(ips, ip.String())
+	}
+	return ips, nil
+}
+
+// removeNewAntiSpoof undoes the anti-spoofing rules set for ips on brPort by
+// a failed registration, except those a registered container relies on.
+// Errors are only logged, the registration failing anyway.
+func removeNewAntiSpoof(brPort string, ips []string) {
+	for _, ip := range ips {
+		if m, ok := mds.getByIP(ip); ok && m.brPort == brPort {
+			continue
+		}
+		if err := fw.RemoveAntiSpoof(brPort, ip); err != nil {
+			log.Printf("failed to remove anti-spoofing of %s on %s: %v", ip, brPort, err)
+		}
 	}
+}
 
-	containerIP := queryValue(r.URL, "container_ip")
-	if containerIP == "" {
+func handleRegisterContainer(w http.ResponseWriter, r *http.Request) {
+	ips, err := containerIPs(r.URL.Query()["container_ip"])
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		fmt.Print(w, "container_ip missing")
+		fmt.Fprint(w, err)
 		return
 	}
 	containerBrPort := queryValue(r.URL, "container_brport")
 	if containerBrPort == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		fmt.Print(w, "container_brport missing")
+		fmt.Fprint(w, "container_brport missing")
 		return
 	}
 
-	m := &metadata{
-		apps: make(map[string]*schema.ImageManifest),
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&m.manifest); err != nil {
+	var cm schema.ContainerRuntimeManifest
+	if err := json.NewDecoder(r.Body).Decode(&cm); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "JSON-decoding failed: %v", err)
 		return
 	}
+	setContainer(w, cm.UUID)
 
-	if err := antiSpoof(containerBrPort, containerIP); err != nil {
+	for i, ip := range ips {
+		if err := fw.AntiSpoof(containerBrPort, ip); err != nil {
+			removeNewAntiSpoof(containerBrPort, ips[:i])
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed to set anti-spoofing: %v", err)
+			return
+		}
+	}
+
+	evicted, err := mds.addContainer(ips, containerBrPort, cm)
+	if err != nil {
+		removeNewAntiSpoof(containerBrPort, ips)
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "failed to set anti-spoofing: %v", err)
+		fmt.Fprintf(w, "failed to register container: %v", err)
 		return
 	}
 
-	metadataByIP[containerIP] = m
-	metadataByUID[m.manifest.UUID] = m
+	// the containers whose IP has been reused are gone, and a container
+	// registering again may have moved: lift the anti-spoofing rules of the
+	// replaced registrations, except those the new one now relies on
+	kept := make(map[string]bool)
+	for _, ip := range ips {
+		kept[containerBrPort+" "+ip] = true
+	}
+	for _, m := range evicted {
+		for _, ip := range m.ips {
+			if kept[m.brPort+" "+ip] {
+				continue
+			}
+			if err := fw.RemoveAntiSpoof(m.brPort, ip); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "failed to remove anti-spoofing of replaced container: %v", err)
+				return
+			}
+		}
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
 func handleRegisterApp(w http.ResponseWriter, r *http.Request) {
-	remoteIP := strings.Split(r.RemoteAddr, ":")[0]
-	if _, ok := metadataByIP[remoteIP]; ok {
-		// not allowed from container IP
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
 	uid, err := types.NewUUID(mux.Vars(r)["uid"])
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
@@ -115,13 +183,6 @@ func handleRegisterApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	m, ok := metadataByUID[*uid]
-	if !ok {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Container with given UUID not found")
-		return
-	}
-
 	an := mux.Vars(r)["app"]
 
 	app := &schema.ImageManifest{}
@@ -131,21 +192,75 @@ func handleRegisterApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	m.apps[an] = app
+	switch err := mds.addApp(*uid, an, app); err {
+	case nil:
+	case errContainerNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Container with given UUID not found")
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to register app: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleUnregisterContainer(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(mux.Vars(r)["uid"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "UUID is missing or mulformed: %v", err)
+		return
+	}
+
+	if err := unregisterContainer(*uid); err != nil {
+		if err == errContainerNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Container with given UUID not found")
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed to unregister container: %v", err)
+		}
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// unregisterContainer lifts the anti-spoofing rules of the container with
+// the given UUID and forgets it. The container stays registered if a rule
+// could not be removed, so that unregistering it again finishes the cleanup.
+func unregisterContainer(uid types.UUID) error {
+	m, ok := mds.getByUID(uid)
+	if !ok {
+		return errContainerNotFound
+	}
+	var errs []string
+	for _, ip := range m.ips {
+		if err := fw.RemoveAntiSpoof(m.brPort, ip); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove anti-spoofing: %s", strings.Join(errs, "; "))
+	}
+	_, err := mds.removeContainer(uid)
+	return err
+}
+
 func containerGet(h func(w http.ResponseWriter, r *http.Request, m *metadata)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		remoteIP := strings.Split(r.RemoteAddr, ":")[0]
-		m, ok := metadataByIP[remoteIP]
+		remoteIP := remoteIP(r)
+		m, ok := mds.getByIP(remoteIP)
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
 			fmt.Fprintf(w, "metadata by remoteIP (%v) not found", remoteIP)
 			return
 		}
 
+		setContainer(w, m.manifest.UUID)
 		h(w, r, m)
 	}
 }
@@ -275,13 +390,6 @@ func handleAppID(w http.ResponseWriter, r *http.Request, m *metadata, am *schema
 	w.Write([]byte(a.ImageID.String()))
 }
 
-func initCrypto() error {
-	if n, err := rand.Reader.Read(hmacKey[:]); err != nil || n != len(hmacKey) {
-		return fmt.Errorf("failed to generate HMAC Key")
-	}
-	return nil
-}
-
 func digest(r io.Reader) ([]byte, error) {
 	digest := sha256.New()
 	if _, err := io.Copy(digest, r); err != nil {
@@ -291,13 +399,14 @@ func digest(r io.Reader) ([]byte, error) {
 }
 
 func handleContainerSign(w http.ResponseWriter, r *http.Request) {
-	remoteIP := strings.Split(r.RemoteAddr, ":")[0]
-	m, ok := metadataByIP[remoteIP]
+	remoteIP := remoteIP(r)
+	m, ok := mds.getByIP(remoteIP)
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "Metadata by remoteIP (%v) not found", remoteIP)
 		return
 	}
+	setContainer(w, m.manifest.UUID)
 
 	// compute message digest
 	d, err := digest(r.Body)
@@ -308,7 +417,7 @@ func handleContainerSign(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// HMAC(UID:digest)
-	h := hmac.New(sha256.New, hmacKey[:])
+	h := hmac.New(sha256.New, mds.hmacKey[:])
 	h.Write(m.manifest.UUID[:])
 	h.Write(d)
 
@@ -319,6 +428,7 @@ func handleContainerSign(w http.ResponseWriter, r *http.Request) {
 	enc.Write(d)
 	enc.Write(h.Sum(nil))
 	enc.Close()
+	stats.sign()
 }
 
 func handleContainerVerify(w http.ResponseWriter, r *http.Request) {
@@ -336,23 +446,34 @@ func handleContainerVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(sig) != 2*sha256.Size {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "signature field has wrong length")
+		return
+	}
+
 	digest := sig[:sha256.Size]
 	sum := sig[sha256.Size:]
 
-	h := hmac.New(sha256.New, hmacKey[:])
+	h := hmac.New(sha256.New, mds.hmacKey[:])
 	h.Write(uid[:])
 	h.Write(digest)
 
-	if hmac.Equal(sum, h.Sum(nil)) {
+	valid := hmac.Equal(sum, h.Sum(nil))
+	stats.verify(valid)
+	if valid {
 		w.WriteHeader(http.StatusOK)
 	} else {
 		w.WriteHeader(http.StatusForbidden)
 	}
 }
 
+// httpResp records what is needed for the access log of a request
 type httpResp struct {
-	writer http.ResponseWriter
-	status int
+	writer    http.ResponseWriter
+	status    int
+	bytes     int
+	container string // UUID of the container the request is about, if known
 }
 
 func (r *httpResp) Header() http.Header {
@@ -360,7 +481,12 @@ func (r *httpResp) Header() http.Header {
 }
 
 func (r *httpResp) Write(d []byte) (int, error) {
-	return r.writer.Write(d)
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.writer.Write(d)
+	r.bytes += n
+	return n, err
 }
 
 func (r *httpResp) WriteHeader(status int) {
@@ -368,44 +494,218 @@ func (r *httpResp) WriteHeader(status int) {
 	r.writer.WriteHeader(status)
 }
 
-func logReq(h func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+// setContainer records the container w is answering about, for the access
+// log
+func setContainer(w http.ResponseWriter, uid types.UUID) {
+	if resp, ok := w.(*httpResp); ok {
+		resp.container = uid.String()
+	}
+}
+
+// accessLogEntry is logged, as a line of JSON, for every request
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Route     string    `json:"route"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	RemoteIP  string    `json:"remoteIP,omitempty"`
+	Container string    `json:"container,omitempty"`
+	App       string    `json:"app,omitempty"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	LatencyMS float64   `json:"latencyMS"`
+}
+
+// accessLog receives the access log
+var accessLog io.Writer = os.Stdout
+
+// logReq logs the requests handled by h on route, and accounts for them in
+// the metrics
+func logReq(route string, h func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		resp := &httpResp{w, 0}
+		start := time.Now()
+		resp := &httpResp{writer: w}
 		h(resp, r)
-		fmt.Printf("%v %v - %v\n", r.Method, r.RequestURI, resp.status)
+		d := time.Since(start)
+		if resp.status == 0 {
+			resp.status = http.StatusOK
+		}
+		stats.request(route, resp.status, d)
+
+		e := accessLogEntry{
+			Time:      start.UTC(),
+			Route:     route,
+			Method:    r.Method,
+			URI:       r.URL.RequestURI(),
+			Container: resp.container,
+			App:       mux.Vars(r)["app"],
+			Status:    resp.status,
+			Bytes:     resp.bytes,
+			LatencyMS: float64(d) / float64(time.Millisecond),
+		}
+		// requests on the registration socket have no remote address
+		if r.RemoteAddr != "@" && r.RemoteAddr != "" {
+			e.RemoteIP = remoteIP(r)
+		}
+		if e.Container == "" {
+			e.Container = mux.Vars(r)["uid"]
+		}
+		buf, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("failed to encode access log entry: %v", err)
+			return
+		}
+		accessLog.Write(append(buf, '\n'))
 	}
 }
 
-func main() {
-	if err := setupIPTables(); err != nil {
-		log.Fatal(err)
-	}
+// handle routes path to h on r, logging the requests
+func handle(r *mux.Router, path string, h func(w http.ResponseWriter, r *http.Request)) *mux.Route {
+	return r.HandleFunc(path, logReq(path, h))
+}
 
-	if err := initCrypto(); err != nil {
-		log.Fatal(err)
-	}
+// newRegistrationRouter returns the router of the registration API, served
+// on the registration socket only
+func newRegistrationRouter() *mux.Router {
+	r := mux.NewRouter()
+	handle(r, "/containers/", handleRegisterContainer).Methods("POST")
+	handle(r, "/containers/{uid}/{app:.*}", handleRegisterApp).Methods("PUT")
+	handle(r, "/containers/{uid}", handleUnregisterContainer).Methods("DELETE")
+	handle(r, "/metrics", handleMetrics).Methods("GET")
+	return r
+}
 
+// newRouter returns the router of the metadata API, served to the containers
+// over TCP
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
-	r.HandleFunc("/containers/", logReq(handleRegisterContainer)).Methods("POST")
-	r.HandleFunc("/containers/{uid}/{app:.*}", logReq(handleRegisterApp)).Methods("PUT")
+	handle(r, "/identity/public-key", handleIdentityPublicKey).Methods("GET")
 
 	acRtr := r.Headers("Metadata-Flavor", "AppContainer header").
 		PathPrefix("/acMetadata/v1").Subrouter()
 
 	mr := acRtr.Methods("GET").Subrouter()
 
-	mr.HandleFunc("/container/annotations/", logReq(containerGet(handleContainerAnnotations)))
-	mr.HandleFunc("/container/annotations/{name}", logReq(containerGet(handleContainerAnnotation)))
-	mr.HandleFunc("/container/manifest", logReq(containerGet(handleContainerManifest)))
-	mr.HandleFunc("/container/uid", logReq(containerGet(handleContainerUID)))
+	handle(mr, "/container/annotations/", containerGet(handleContainerAnnotations))
+	handle(mr, "/container/annotations/{name}", containerGet(handleContainerAnnotation))
+	handle(mr, "/container/manifest", containerGet(handleContainerManifest))
+	handle(mr, "/container/uid", containerGet(handleContainerUID))
+	handle(mr, "/container/identity", containerGet(handleContainerIdentity))
+	handle(mr, "/container/addresses", containerGet(handleContainerAddresses))
+
+	handle(mr, "/apps/{app:.*}/annotations/", appGet(handleAppAnnotations))
+	handle(mr, "/apps/{app:.*}/annotations/{name}", appGet(handleAppAnnotation))
+	handle(mr, "/apps/{app:.*}/image/manifest", appGet(handleImageManifest))
+	handle(mr, "/apps/{app:.*}/image/id", appGet(handleAppID))
+	handle(mr, "/apps/{app:.*}/env", appGet(handleAppEnv))
+	handle(mr, "/apps/{app:.*}/mounts", appGet(handleAppMounts))
+	handle(mr, "/apps/{app:.*}/ports", appGet(handleAppPorts))
+
+	handle(acRtr, "/container/hmac/sign", handleContainerSign).Methods("POST")
+	handle(acRtr, "/container/hmac/verify", handleContainerVerify).Methods("POST")
+
+	return r
+}
+
+func main() {
+	flag.Parse()
+
+	if err := serve(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// firewallConfig validates the addresses given on the command line
+func firewallConfig() (*fwConfig, error) {
+	_, port, err := net.SplitHostPort(flagListen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %v", flagListen, err)
+	}
+	cfg := &fwConfig{
+		metaPort:  flagMetaPort,
+		redirPort: port,
+	}
+
+	ip := net.ParseIP(flagMetaIP)
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 metadata address %q", flagMetaIP)
+	}
+	cfg.metaIP = ip.String()
+
+	if flagMetaIP6 != "" {
+		ip := net.ParseIP(flagMetaIP6)
+		if ip == nil || ip.To4() != nil || !ip.IsLinkLocalUnicast() {
+			return nil, fmt.Errorf("invalid IPv6 link-local metadata address %q", flagMetaIP6)
+		}
+		cfg.metaIP6 = ip.String()
+	}
+
+	if _, err := strconv.ParseUint(flagMetaPort, 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid metadata port %q", flagMetaPort)
+	}
+	return cfg, nil
+}
 
-	mr.HandleFunc("/apps/{app:.*}/annotations/", logReq(appGet(handleAppAnnotations)))
-	mr.HandleFunc("/apps/{app:.*}/annotations/{name}", logReq(appGet(handleAppAnnotation)))
-	mr.HandleFunc("/apps/{app:.*}/image/manifest", logReq(appGet(handleImageManifest)))
-	mr.HandleFunc("/apps/{app:.*}/image/id", logReq(appGet(handleAppID)))
+// serve runs the service until it fails or is told to stop, cleaning up the
+// firewall on the way out
+func serve() error {
+	cfg, err := firewallConfig()
+	if err != nil {
+		return err
+	}
+	if fw, err = newFirewall(flagFirewall, cfg); err != nil {
+		return err
+	}
+	if mds, err = newStore(flagStateDir); err != nil {
+		return err
+	}
+	if idKey, err = loadIdentityKey(flagIdentityKey, flagStateDir); err != nil {
+		return err
+	}
 
-	acRtr.HandleFunc("/container/hmac/sign", logReq(handleContainerSign)).Methods("POST")
-	acRtr.HandleFunc("/container/hmac/verify", logReq(handleContainerVerify)).Methods("POST")
+	if err := fw.Setup(); err != nil {
+		return fmt.Errorf("failed to set up firewall: %v", err)
+	}
+	defer func() {
+		if err := fw.Teardown(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	// containers registered with a previous instance
+	for _, m := range mds.containers() {
+		for _, ip := range m.ips {
+			if err := fw.AntiSpoof(m.brPort, ip); err != nil {
+				return fmt.Errorf("failed to set anti-spoofing: %v", err)
+			}
+		}
+	}
 
-	log.Fatal(http.ListenAndServe(":4444", r))
+	regL, mdL, err := listeners()
+	if err != nil {
+		return err
+	}
+
+	if flagReapInterval > 0 {
+		go reapLoop(filepath.Join(flagRktDir, "containers"), flagReapInterval)
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- http.Serve(regL, newRegistrationRouter())
+	}()
+	go func() {
+		errc <- http.Serve(mdL, newRouter())
+	}()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err = <-errc:
+		return err
+	case sig := <-sigc:
+		log.Printf("received %v, shutting down", sig)
+		return nil
+	}
 }
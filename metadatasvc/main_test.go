@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+const (
+	testUUID = "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f10"
+)
+
+// fakeFirewall records the anti-spoofing rules in place
+type fakeFirewall struct {
+	mu     sync.Mutex
+	rules  map[string]bool
+	stuck  map[string]bool // rules failing to be removed
+	broken map[string]bool // rules failing to be installed
+}
+
+func (f *fakeFirewall) Setup() error    { return nil }
+func (f *fakeFirewall) Teardown() error { return nil }
+
+func (f *fakeFirewall) AntiSpoof(brPort, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.broken[brPort+" "+ip] {
+		return fmt.Errorf("rule %s %s broken", brPort, ip)
+	}
+	f.rules[brPort+" "+ip] = true
+	return nil
+}
+
+func (f *fakeFirewall) RemoveAntiSpoof(brPort, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stuck[brPort+" "+ip] {
+		return fmt.Errorf("rule %s %s stuck", brPort, ip)
+	}
+	delete(f.rules, brPort+" "+ip)
+	return nil
+}
+
+// setupStore installs a fresh store, persisted in dir if not empty, and a
+// fake firewall, with fresh metrics and no access log
+func setupStore(t *testing.T, dir string) {
+	fw = &fakeFirewall{
+		rules:  make(map[string]bool),
+		stuck:  make(map[string]bool),
+		broken: make(map[string]bool),
+	}
+	stats = newMetrics()
+	accessLog = ioutil.Discard
+	var err error
+	if mds, err = newStore(dir); err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+}
+
+// do sends a request to the metadata API, from remoteAddr
+func do(method, u, remoteAddr string, body []byte) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("Metadata-Flavor", "AppContainer header")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, req)
+	return w
+}
+
+// doReg sends a request to the registration API
+func doReg(method, u string, body []byte) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	req.RemoteAddr = "@"
+	w := httptest.NewRecorder()
+	newRegistrationRouter().ServeHTTP(w, req)
+	return w
+}
+
+func containerManifest(uuid string) ([]byte, error) {
+	uid, err := types.NewUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	cm := schema.ContainerRuntimeManifest{
+		ACKind: "ContainerRuntimeManifest",
+		UUID:   *uid,
+		Apps: schema.AppList{
+			{Name: "example.com/app", Annotations: types.Annotations{"role": "web"}},
+		},
+		Annotations: types.Annotations{"env": "test"},
+	}
+	return json.Marshal(cm)
+}
+
+// register registers a container with the given UUID and IP, running a
+// single app, the way stage1 does
+func register(uuid, ip string) error {
+	cm, err := containerManifest(uuid)
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("container_ip", ip)
+	v.Set("container_brport", "veth0")
+	w := doReg("POST", "/containers/?"+v.Encode(), cm)
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("error registering container: %d %s", w.Code, w.Body)
+	}
+
+	am, err := json.Marshal(schema.ImageManifest{Name: "example.com/app"})
+	if err != nil {
+		return err
+	}
+	w = doReg("PUT", "/containers/"+uuid+"/example.com/app", am)
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("error registering app: %d %s", w.Code, w.Body)
+	}
+	return nil
+}
+
+func mustRegister(t *testing.T, uuid, ip string) {
+	if err := register(uuid, ip); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func get(path, ip string) (int, string) {
+	w := do("GET", "/acMetadata/v1"+path, net.JoinHostPort(ip, "1234"), nil)
+	return w.Code, w.Body.String()
+}
+
+func TestRegistration(t *testing.T) {
+	setupStore(t, "")
+	mustRegister(t, testUUID, "10.0.0.2")
+
+	tests := []struct {
+		path string
+		ip   string
+		code int
+		body string
+	}{
+		{"/container/uid", "10.0.0.2", http.StatusOK, testUUID},
+		{"/container/annotations/env", "10.0.0.2", http.StatusOK, "test"},
+		{"/container/annotations/missing", "10.0.0.2", http.StatusNotFound, ""},
+		{"/apps/example.com/app/annotations/role", "10.0.0.2", http.StatusOK, "web"},
+		{"/apps/other/annotations/", "10.0.0.2", http.StatusNotFound, ""},
+		{"/container/uid", "10.0.0.3", http.StatusNotFound, ""},
+	}
+	for i, tt := range tests {
+		code, body := get(tt.path, tt.ip)
+		if code != tt.code {
+			t.Errorf("#%d: %s: got status %d, wanted %d", i, tt.path, code, tt.code)
+		}
+		if tt.body != "" && body != tt.body {
+			t.Errorf("#%d: %s: got %q, wanted %q", i, tt.path, body, tt.body)
+		}
+	}
+
+	// the registration API is not served to containers
+	cm, err := containerManifest(testUUID)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	v := url.Values{}
+	v.Set("container_ip", "10.0.0.4")
+	v.Set("container_brport", "veth1")
+	w := do("POST", "/containers/?"+v.Encode(), "10.0.0.2:1234", cm)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("registration from container: got status %d, wanted %d", w.Code, http.StatusNotFound)
+	}
+
+	w = doReg("PUT", "/containers/6733c3a4-4d1f-4b6e-8d3c-000000000000/app", []byte("{}"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("app of unknown container: got status %d, wanted %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	setupStore(t, "")
+	mustRegister(t, testUUID, "10.0.0.2")
+
+	w := do("POST", "/acMetadata/v1/container/hmac/sign", "10.0.0.2:1234", []byte("message"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("error signing: %d %s", w.Code, w.Body)
+	}
+	sig := w.Body.String()
+
+	verify := func(uid, sig string) int {
+		v := url.Values{}
+		v.Set("uid", uid)
+		v.Set("signature", sig)
+		req, err := http.NewRequest("POST", "/acMetadata/v1/container/hmac/verify", strings.NewReader(v.Encode()))
+		if err != nil {
+			t.Fatalf("error creating request: %v", err)
+		}
+		req.RemoteAddr = "192.168.0.1:1234"
+		req.Header.Set("Metadata-Flavor", "AppContainer header")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := verify(testUUID, sig); code != http.StatusOK {
+		t.Errorf("verifying signature: got status %d, wanted %d", code, http.StatusOK)
+	}
+	if code := verify("6733c3a4-4d1f-4b6e-8d3c-000000000000", sig); code != http.StatusForbidden {
+		t.Errorf("verifying signature of other container: got status %d, wanted %d", code, http.StatusForbidden)
+	}
+	if code := verify(testUUID, "c2hvcnQ="); code != http.StatusBadRequest {
+		t.Errorf("verifying short signature: got status %d, wanted %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	setupStore(t, dir)
+	mustRegister(t, testUUID, "10.0.0.2")
+	w := do("POST", "/acMetadata/v1/container/hmac/sign", "10.0.0.2:1234", []byte("message"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("error signing: %d %s", w.Code, w.Body)
+	}
+	key := mds.hmacKey
+
+	// restart
+	setupStore(t, dir)
+	if mds.hmacKey != key {
+		t.Errorf("HMAC key not persisted")
+	}
+	if code, body := get("/container/uid", "10.0.0.2"); code != http.StatusOK || body != testUUID {
+		t.Errorf("container not persisted: got %d %q", code, body)
+	}
+	if code, _ := get("/apps/example.com/app/image/manifest", "10.0.0.2"); code != http.StatusOK {
+		t.Errorf("app not persisted: got status %d", code)
+	}
+
+	// a new container reusing the IP replaces the old one
+	other := "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f11"
+	mustRegister(t, other, "10.0.0.2")
+	setupStore(t, dir)
+	if code, body := get("/container/uid", "10.0.0.2"); code != http.StatusOK || body != other {
+		t.Errorf("container not replaced: got %d %q", code, body)
+	}
+	if len(mds.byUID) != 1 {
+		t.Errorf("unexpected number of containers: %d, wanted 1", len(mds.byUID))
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	setupStore(t, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		uuid := fmt.Sprintf("6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f%02d", i)
+		ip := fmt.Sprintf("10.0.1.%d", i+1)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := register(uuid, ip); err != nil {
+				t.Errorf("%v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				get("/apps/example.com/app/annotations/", ip)
+				get("/container/manifest", ip)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		ip := fmt.Sprintf("10.0.1.%d", i+1)
+		if code, _ := get("/apps/example.com/app/image/id", ip); code != http.StatusOK {
+			t.Errorf("%s: got status %d, wanted %d", ip, code, http.StatusOK)
+		}
+	}
+}
+
+func TestRegisterFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	setupStore(t, dir)
+	mustRegister(t, testUUID, "10.0.0.2")
+	other := "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f11"
+	cm, err := containerManifest(other)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	f := fw.(*fakeFirewall)
+
+	// the rules set before the failing one are removed, except those the
+	// registered container relies on
+	f.broken["veth0 fd00::3"] = true
+	for _, ip := range []string{"10.0.0.3", "10.0.0.2"} {
+		w := doReg("POST", "/containers/?container_brport=veth0&container_ip="+ip+"&container_ip=fd00::3", cm)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("registering with a broken rule: got status %d, wanted %d", w.Code, http.StatusInternalServerError)
+		}
+		if len(f.rules) != 1 || !f.rules["veth0 10.0.0.2"] {
+			t.Errorf("unexpected anti-spoofing rules after failed registration: %v", f.rules)
+		}
+	}
+	delete(f.broken, "veth0 fd00::3")
+
+	// and so are all of them if the container cannot be saved
+	if err := os.RemoveAll(filepath.Join(dir, "containers")); err != nil {
+		t.Fatalf("error removing state dir: %v", err)
+	}
+	w := doReg("POST", "/containers/?container_brport=veth0&container_ip=10.0.0.3", cm)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("registering with a broken state dir: got status %d, wanted %d", w.Code, http.StatusInternalServerError)
+	}
+	if len(f.rules) != 1 || !f.rules["veth0 10.0.0.2"] {
+		t.Errorf("unexpected anti-spoofing rules after failed registration: %v", f.rules)
+	}
+	if code, body := get("/container/uid", "10.0.0.2"); code != http.StatusOK || body != testUUID {
+		t.Errorf("registered container not served: got %d %q", code, body)
+	}
+}
+
+func TestReregister(t *testing.T) {
+	setupStore(t, "")
+	mustRegister(t, testUUID, "10.0.0.2")
+	mustRegister(t, testUUID, "10.0.0.3")
+
+	rules := fw.(*fakeFirewall).rules
+	if len(rules) != 1 || !rules["veth0 10.0.0.3"] {
+		t.Errorf("anti-spoofing rules not moved along with the container: %v", rules)
+	}
+	if code, _ := get("/container/uid", "10.0.0.2"); code != http.StatusNotFound {
+		t.Errorf("previous address still served: got status %d", code)
+	}
+
+	// registering again at the same address keeps its rule
+	mustRegister(t, testUUID, "10.0.0.3")
+	if len(rules) != 1 || !rules["veth0 10.0.0.3"] {
+		t.Errorf("anti-spoofing rule removed: %v", rules)
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	setupStore(t, "")
+	mustRegister(t, testUUID, "10.0.0.2")
+
+	rules := fw.(*fakeFirewall).rules
+	if !rules["veth0 10.0.0.2"] {
+		t.Errorf("anti-spoofing rule not installed: %v", rules)
+	}
+
+	if w := do("DELETE", "/containers/"+testUUID, "10.0.0.2:1234", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unregistration from container: got status %d, wanted %d", w.Code, http.StatusNotFound)
+	}
+	if w := doReg("DELETE", "/containers/"+testUUID, nil); w.Code != http.StatusOK {
+		t.Fatalf("error unregistering container: %d %s", w.Code, w.Body)
+	}
+	if len(rules) != 0 {
+		t.Errorf("anti-spoofing rules not removed: %v", rules)
+	}
+	if code, _ := get("/container/uid", "10.0.0.2"); code != http.StatusNotFound {
+		t.Errorf("unregistered container still served: got status %d", code)
+	}
+	if w := doReg("DELETE", "/containers/"+testUUID, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unregistering twice: got status %d, wanted %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUnregisterRetry(t *testing.T) {
+	setupStore(t, "")
+	cm, err := containerManifest(testUUID)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	if w := doReg("POST", "/containers/?container_brport=veth0&container_ip=10.0.0.2&container_ip=fd00::2", cm); w.Code != http.StatusOK {
+		t.Fatalf("error registering container: %d %s", w.Code, w.Body)
+	}
+
+	f := fw.(*fakeFirewall)
+	f.stuck["veth0 10.0.0.2"] = true
+	if w := doReg("DELETE", "/containers/"+testUUID, nil); w.Code != http.StatusInternalServerError {
+		t.Fatalf("unregistering with a stuck rule: got status %d, wanted %d", w.Code, http.StatusInternalServerError)
+	}
+	// the other rules are removed anyway
+	if f.rules["veth0 fd00::2"] {
+		t.Errorf("anti-spoofing rule not removed: %v", f.rules)
+	}
+
+	// and a retry finishes the cleanup
+	delete(f.stuck, "veth0 10.0.0.2")
+	if w := doReg("DELETE", "/containers/"+testUUID, nil); w.Code != http.StatusOK {
+		t.Fatalf("error unregistering container: %d %s", w.Code, w.Body)
+	}
+	if len(f.rules) != 0 {
+		t.Errorf("anti-spoofing rules not removed: %v", f.rules)
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	setupStore(t, "")
+	cm, err := containerManifest(testUUID)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+
+	for _, q := range []string{
+		"container_ip=10.0.0.2&container_ip=10.0.0.3",
+		"container_ip=fd00::2&container_ip=fd00::3",
+		"container_ip=fd00::zz",
+		"container_ip=",
+	} {
+		if w := doReg("POST", "/containers/?container_brport=veth0&"+q, cm); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, wanted %d", q, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	w := doReg("POST", "/containers/?container_brport=veth0&container_ip=10.0.0.2&container_ip=FD00:0::2", cm)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error registering container: %d %s", w.Code, w.Body)
+	}
+	rules := fw.(*fakeFirewall).rules
+	if !rules["veth0 10.0.0.2"] || !rules["veth0 fd00::2"] {
+		t.Errorf("anti-spoofing rules not installed for both addresses: %v", rules)
+	}
+
+	for _, ip := range []string{"10.0.0.2", "::ffff:10.0.0.2", "fd00::2", "fd00:0:0::2", "fd00::2%eth0"} {
+		if code, body := get("/container/uid", ip); code != http.StatusOK || body != testUUID {
+			t.Errorf("%s: got %d %q", ip, code, body)
+		}
+	}
+	if code, _ := get("/container/uid", "fd00::3"); code != http.StatusNotFound {
+		t.Errorf("unknown address: got status %d, wanted %d", code, http.StatusNotFound)
+	}
+
+	// reusing either address replaces the container
+	other := "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f11"
+	mustRegister(t, other, "fd00::2")
+	if code, _ := get("/container/uid", "10.0.0.2"); code != http.StatusNotFound {
+		t.Errorf("replaced container still served: got status %d", code)
+	}
+	if rules["veth0 10.0.0.2"] || !rules["veth0 fd00::2"] {
+		t.Errorf("anti-spoofing rules not updated for replaced container: %v", rules)
+	}
+
+	if w := doReg("DELETE", "/containers/"+testUUID, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unregistering replaced container: got status %d, wanted %d", w.Code, http.StatusNotFound)
+	}
+	if w := doReg("DELETE", "/containers/"+other, nil); w.Code != http.StatusOK {
+		t.Fatalf("error unregistering container: %d %s", w.Code, w.Body)
+	}
+	if rules["veth0 fd00::2"] {
+		t.Errorf("anti-spoofing rule not removed: %v", rules)
+	}
+}
+
+func TestReap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	running := "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f01"
+	stopped := "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f02"
+	collected := "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f03"
+
+	setupStore(t, "")
+	mustRegister(t, running, "10.0.0.1")
+	mustRegister(t, stopped, "10.0.0.2")
+	mustRegister(t, collected, "10.0.0.3")
+
+	for _, uuid := range []string{running, stopped} {
+		if err := os.Mkdir(filepath.Join(dir, uuid), 0700); err != nil {
+			t.Fatalf("error creating container dir: %v", err)
+		}
+	}
+	l, err := lock.ExclusiveLock(filepath.Join(dir, running))
+	if err != nil {
+		t.Fatalf("error locking container dir: %v", err)
+	}
+	defer l.Close()
+
+	reap(dir)
+
+	if code, _ := get("/container/uid", "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("running container expired: got status %d", code)
+	}
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3"} {
+		if code, _ := get("/container/uid", ip); code != http.StatusNotFound {
+			t.Errorf("%s: stopped container not expired: got status %d", ip, code)
+		}
+	}
+}
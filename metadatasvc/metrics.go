@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics are kept in memory and exposed in the Prometheus text format
+type metrics struct {
+	mu           sync.Mutex
+	requests     map[[2]string]uint64 // by route and status
+	durations    map[string]float64   // total seconds spent, by route
+	signs        uint64
+	verification map[bool]uint64 // by validity of the signature
+}
+
+var stats = newMetrics()
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:     make(map[[2]string]uint64),
+		durations:    make(map[string]float64),
+		verification: make(map[bool]uint64),
+	}
+}
+
+func (m *metrics) request(route string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[[2]string{route, strconv.Itoa(status)}]++
+	m.durations[route] += d.Seconds()
+}
+
+func (m *metrics) sign() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.signs++
+}
+
+func (m *metrics) verify(valid bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.verification[valid]++
+}
+
+// write writes the metrics to w, along with the number of registered
+// containers
+func (m *metrics) write(w io.Writer, containers int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP metadatasvc_requests_total Requests handled, by route and status.")
+	fmt.Fprintln(w, "# TYPE metadatasvc_requests_total counter")
+	var keys [][2]string
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "metadatasvc_requests_total{route=%s,code=%q} %d\n", quoteLabel(k[0]), k[1], m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP metadatasvc_request_duration_seconds_sum Time spent handling requests, by route.")
+	fmt.Fprintln(w, "# TYPE metadatasvc_request_duration_seconds_sum counter")
+	var routes []string
+	for r := range m.durations {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	for _, r := range routes {
+		fmt.Fprintf(w, "metadatasvc_request_duration_seconds_sum{route=%s} %g\n", quoteLabel(r), m.durations[r])
+	}
+
+	fmt.Fprintln(w, "# HELP metadatasvc_registered_containers Containers currently registered.")
+	fmt.Fprintln(w, "# TYPE metadatasvc_registered_containers gauge")
+	fmt.Fprintf(w, "metadatasvc_registered_containers %d\n", containers)
+
+	fmt.Fprintln(w, "# HELP metadatasvc_hmac_signatures_total Messages signed on behalf of containers.")
+	fmt.Fprintln(w, "# TYPE metadatasvc_hmac_signatures_total counter")
+	fmt.Fprintf(w, "metadatasvc_hmac_signatures_total %d\n", m.signs)
+
+	fmt.Fprintln(w, "# HELP metadatasvc_hmac_verifications_total Signatures verified, by result.")
+	fmt.Fprintln(w, "# TYPE metadatasvc_hmac_verifications_total counter")
+	fmt.Fprintf(w, "metadatasvc_hmac_verifications_total{result=\"valid\"} %d\n", m.verification[true])
+	fmt.Fprintf(w, "metadatasvc_hmac_verifications_total{result=\"invalid\"} %d\n", m.verification[false])
+}
+
+// quoteLabel quotes a label value as the Prometheus text format wants it
+func quoteLabel(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	stats.write(w, len(mds.containers()))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	setupStore(t, "")
+	mustRegister(t, testUUID, "10.0.0.2")
+
+	buf := &bytes.Buffer{}
+	accessLog = buf
+	get("/apps/example.com/app/annotations/role", "10.0.0.2")
+	get("/container/uid", "10.0.0.3")
+
+	dec := json.NewDecoder(buf)
+	var e accessLogEntry
+	if err := dec.Decode(&e); err != nil {
+		t.Fatalf("error decoding access log: %v", err)
+	}
+	if e.Route != "/apps/{app:.*}/annotations/{name}" || e.Method != "GET" ||
+		e.URI != "/acMetadata/v1/apps/example.com/app/annotations/role" ||
+		e.RemoteIP != "10.0.0.2" || e.Container != testUUID || e.App != "example.com/app" ||
+		e.Status != http.StatusOK || e.Bytes != len("web") || e.LatencyMS < 0 {
+		t.Errorf("unexpected access log entry: %+v", e)
+	}
+
+	e = accessLogEntry{}
+	if err := dec.Decode(&e); err != nil {
+		t.Fatalf("error decoding access log: %v", err)
+	}
+	if e.RemoteIP != "10.0.0.3" || e.Container != "" || e.Status != http.StatusNotFound {
+		t.Errorf("unexpected access log entry: %+v", e)
+	}
+
+	// registrations are logged with the container, without remote address
+	buf.Reset()
+	doReg("DELETE", "/containers/"+testUUID, nil)
+	e = accessLogEntry{}
+	if err := json.NewDecoder(buf).Decode(&e); err != nil {
+		t.Fatalf("error decoding access log: %v", err)
+	}
+	if e.RemoteIP != "" || e.Container != testUUID || e.Status != http.StatusOK {
+		t.Errorf("unexpected access log entry: %+v", e)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	setupStore(t, "")
+	mustRegister(t, testUUID, "10.0.0.2")
+	mustRegister(t, "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f11", "10.0.0.3")
+	get("/container/uid", "10.0.0.2")
+	get("/container/uid", "10.0.0.2")
+	get("/container/uid", "10.0.0.4")
+	do("POST", "/acMetadata/v1/container/hmac/sign", "10.0.0.2:1234", []byte("message"))
+
+	w := doReg("GET", "/metrics", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error getting metrics: %d %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	for _, l := range []string{
+		`metadatasvc_requests_total{route="/container/uid",code="200"} 2`,
+		`metadatasvc_requests_total{route="/container/uid",code="404"} 1`,
+		`metadatasvc_requests_total{route="/containers/",code="200"} 2`,
+		`metadatasvc_registered_containers 2`,
+		`metadatasvc_hmac_signatures_total 1`,
+		`metadatasvc_hmac_verifications_total{result="valid"} 0`,
+	} {
+		if !strings.Contains(body, l+"\n") {
+			t.Errorf("metrics lack %q:\n%s", l, body)
+		}
+	}
+
+	// not served to containers
+	if w := do("GET", "/metrics", "10.0.0.2:1234", nil); w.Code != http.StatusNotFound {
+		t.Errorf("metrics from container: got status %d, wanted %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	if q := quoteLabel("a\"b\\c\nd"); q != `"a\"b\\c\nd"` {
+		t.Errorf("unexpected quoting: %s", q)
+	}
+}
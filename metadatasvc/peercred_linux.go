@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerUID returns the user of the process at the other end of c, which must
+// be a Unix connection
+func peerUID(c net.Conn) (uint32, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return 0, fmt.Errorf("not a unix connection: %v", c.RemoteAddr())
+	}
+	f, err := uc.File()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	cred, err := syscall.GetsockoptUcred(int(f.Fd()), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get peer credentials: %v", err)
+	}
+	return cred.Uid, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+// reapLoop expires the registrations of stopped containers every interval
+func reapLoop(containersDir string, interval time.Duration) {
+	for range time.Tick(interval) {
+		reap(containersDir)
+	}
+}
+
+// reap unregisters the containers that are no longer running. A running
+// container holds an exclusive lock on its directory in containersDir; the
+// directory is moved away once the container is garbage collected.
+func reap(containersDir string) {
+	for _, m := range mds.containers() {
+		uid := m.manifest.UUID
+		l, err := lock.TrySharedLock(filepath.Join(containersDir, uid.String()))
+		switch {
+		case err == nil:
+			l.Close()
+		case os.IsNotExist(err):
+		case err == lock.ErrLocked:
+			continue
+		default:
+			log.Printf("unable to check whether container %v is running: %v", uid, err)
+			continue
+		}
+
+		log.Printf("expiring registration of stopped container %v", uid)
+		if err := unregisterContainer(uid); err != nil && err != errContainerNotFound {
+			log.Printf("failed to unregister container %v: %v", uid, err)
+		}
+	}
+}
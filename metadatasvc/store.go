@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+var errContainerNotFound = errors.New("container not found")
+
+// metadata of a registered container. It is never modified once stored, so
+// it can be used without holding the store lock.
+type metadata struct {
+	ips      []string // in canonical form, see canonicalIP
+	brPort   string
+	manifest schema.ContainerRuntimeManifest
+	apps     map[string]*schema.ImageManifest
+}
+
+// persistedMetadata is the on-disk form of metadata
+type persistedMetadata struct {
+	IPs      []string                         `json:"ips"`
+	BrPort   string                           `json:"brport"`
+	Manifest schema.ContainerRuntimeManifest  `json:"manifest"`
+	Apps     map[string]*schema.ImageManifest `json:"apps"`
+}
+
+// store keeps the registered containers, indexed by IP and UUID, along with
+// the HMAC key used to sign on their behalf. If it has a directory, both
+// survive restarts of the service.
+type store struct {
+	dir     string // empty if not persistent
+	hmacKey [sha256.Size]byte
+
+	mu    sync.RWMutex
+	byIP  map[string]*metadata
+	byUID map[types.UUID]*metadata
+}
+
+// newStore returns a store persisted in dir, loading any state already
+// there, or an in-memory store if dir is empty
+func newStore(dir string) (*store, error) {
+	s := &store{
+		dir:   dir,
+		byIP:  make(map[string]*metadata),
+		byUID: make(map[types.UUID]*metadata),
+	}
+
+	if dir == "" {
+		if err := genHMACKey(s.hmacKey[:]); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	if err := os.MkdirAll(s.containersDir(), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %v", err)
+	}
+	if err := s.loadHMACKey(); err != nil {
+		return nil, err
+	}
+	if err := s.loadContainers(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func genHMACKey(key []byte) error {
+	if n, err := rand.Reader.Read(key); err != nil || n != len(key) {
+		return fmt.Errorf("failed to generate HMAC Key")
+	}
+	return nil
+}
+
+// loadHMACKey reads the HMAC key from the state dir, generating and saving
+// it on first use
+func (s *store) loadHMACKey() error {
+	path := filepath.Join(s.dir, "hmac.key")
+	key, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if len(key) != len(s.hmacKey) {
+			return fmt.Errorf("HMAC key %q is corrupt", path)
+		}
+		copy(s.hmacKey[:], key)
+		return nil
+	case os.IsNotExist(err):
+	default:
+		return fmt.Errorf("failed to read HMAC key: %v", err)
+	}
+
+	if err := genHMACKey(s.hmacKey[:]); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, s.hmacKey[:]); err != nil {
+		return fmt.Errorf("failed to save HMAC key: %v", err)
+	}
+	return nil
+}
+
+func (s *store) loadContainers() error {
+	ls, err := ioutil.ReadDir(s.containersDir())
+	if err != nil {
+		return fmt.Errorf("failed to read state dir: %v", err)
+	}
+	for _, fi := range ls {
+		if filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(s.containersDir(), fi.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read container state: %v", err)
+		}
+		pm := &persistedMetadata{}
+		if err := json.Unmarshal(buf, pm); err != nil {
+			return fmt.Errorf("failed to parse container state %q: %v", fi.Name(), err)
+		}
+		m := &metadata{
+			ips:      pm.IPs,
+			brPort:   pm.BrPort,
+			manifest: pm.Manifest,
+			apps:     pm.Apps,
+		}
+		if m.apps == nil {
+			m.apps = make(map[string]*schema.ImageManifest)
+		}
+		for _, ip := range m.ips {
+			s.byIP[ip] = m
+		}
+		s.byUID[m.manifest.UUID] = m
+	}
+	return nil
+}
+
+func (s *store) containersDir() string {
+	return filepath.Join(s.dir, "containers")
+}
+
+func (s *store) containerPath(uid types.UUID) string {
+	return filepath.Join(s.containersDir(), uid.String()+".json")
+}
+
+// save persists m; it must be called with the lock held
+func (s *store) save(m *metadata) error {
+	if s.dir == "" {
+		return nil
+	}
+	buf, err := json.Marshal(persistedMetadata{
+		IPs:      m.ips,
+		BrPort:   m.brPort,
+		Manifest: m.manifest,
+		Apps:     m.apps,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.containerPath(m.manifest.UUID), buf)
+}
+
+// put indexes m, replacing any container previously registered with the
+// same IPs or UUID, and returns the registrations it replaced: those of the
+// containers whose IP has been reused, and any previous one of the same
+// UUID; it must be called with the lock held
+func (s *store) put(m *metadata) ([]*metadata, error) {
+	if err := s.save(m); err != nil {
+		return nil, fmt.Errorf("failed to save container state: %v", err)
+	}
+	var evicted []*metadata
+	for _, ip := range m.ips {
+		if old, ok := s.byIP[ip]; ok && old.manifest.UUID != m.manifest.UUID {
+			// the IP has been reused, the previous container is gone
+			s.unindex(old)
+			if s.dir != "" {
+				os.Remove(s.containerPath(old.manifest.UUID))
+			}
+			evicted = append(evicted, old)
+		}
+	}
+	if old, ok := s.byUID[m.manifest.UUID]; ok {
+		s.unindex(old)
+		evicted = append(evicted, old)
+	}
+	for _, ip := range m.ips {
+		s.byIP[ip] = m
+	}
+	s.byUID[m.manifest.UUID] = m
+	return evicted, nil
+}
+
+// unindex removes m from the indexes; it must be called with the lock held
+func (s *store) unindex(m *metadata) {
+	for _, ip := range m.ips {
+		if s.byIP[ip] == m {
+			delete(s.byIP, ip)
+		}
+	}
+	if s.byUID[m.manifest.UUID] == m {
+		delete(s.byUID, m.manifest.UUID)
+	}
+}
+
+// addContainer registers a container reachable at the given IPs, attached to
+// the bridge on brPort, returning the containers it replaced, see put
+func (s *store) addContainer(ips []string, brPort string, cm schema.ContainerRuntimeManifest) ([]*metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(&metadata{
+		ips:      ips,
+		brPort:   brPort,
+		manifest: cm,
+		apps:     make(map[string]*schema.ImageManifest),
+	})
+}
+
+// addApp registers the image manifest of an app of the container with the
+// given UUID
+func (s *store) addApp(uid types.UUID, name string, am *schema.ImageManifest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.byUID[uid]
+	if !ok {
+		return errContainerNotFound
+	}
+	m := &metadata{
+		ips:      old.ips,
+		brPort:   old.brPort,
+		manifest: old.manifest,
+		apps:     make(map[string]*schema.ImageManifest),
+	}
+	for n, a := range old.apps {
+		m.apps[n] = a
+	}
+	m.apps[name] = am
+	_, err := s.put(m)
+	return err
+}
+
+// removeContainer deregisters the container with the given UUID, along with
+// its apps, returning its metadata
+func (s *store) removeContainer(uid types.UUID) (*metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.byUID[uid]
+	if !ok {
+		return nil, errContainerNotFound
+	}
+	if s.dir != "" {
+		if err := os.Remove(s.containerPath(uid)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove container state: %v", err)
+		}
+	}
+	s.unindex(m)
+	return m, nil
+}
+
+// containers returns all registered containers
+func (s *store) containers() []*metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ms := make([]*metadata, 0, len(s.byUID))
+	for _, m := range s.byUID {
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// getByIP returns the container registered with the given IP, in any form
+func (s *store) getByIP(ip string) (*metadata, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.byIP[canonicalIP(ip)]
+	return m, ok
+}
+
+// getByUID returns the container registered with the given UUID
+func (s *store) getByUID(uid types.UUID) (*metadata, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.byUID[uid]
+	return m, ok
+}
+
+// canonicalIP returns ip in the form it is indexed with: IPv4 addresses in
+// dotted form, even when mapped into IPv6, and IPv6 addresses without zone
+func canonicalIP(ip string) string {
+	if i := strings.LastIndex(ip, "%"); i >= 0 {
+		ip = ip[:i]
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+// writeFileAtomic replaces the file at path with data, so that it is never
+// seen partially written
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -15,6 +15,12 @@ import (
 const (
 	Stage1Dir = "/stage1"
 	stage2Dir = "/opt/stage2"
+	// directory of the stage1 rootfs the exit statuses of the apps are
+	// written to
+	statusDir = "/rkt/status"
+	// directory of the stage1 rootfs the isolators `rkt enter` applies to
+	// the commands run in the apps are written to
+	isolatorsDir = "/rkt/isolators"
 )
 
 // Stage1RootfsPath returns the directory in root containing the rootfs for stage1
@@ -27,6 +33,51 @@ func ContainerManifestPath(root string) string {
 	return filepath.Join(root, "container")
 }
 
+// RunPath returns the path in root to the file stage0 creates when it hands
+// the container over to stage1
+func RunPath(root string) string {
+	return filepath.Join(root, "run")
+}
+
+// PidPath returns the path in root to the file recording the pid of the
+// container's init, written by stage1 once the container is started
+func PidPath(root string) string {
+	return filepath.Join(root, "pid")
+}
+
+// AppStatusPath returns the path in root to the file recording the exit
+// status of the app with the given image ID, written by stage1 once the app
+// has exited
+func AppStatusPath(root string, imageID types.Hash) string {
+	return filepath.Join(Stage1RootfsPath(root), statusDir, imageID.String())
+}
+
+// AppIsolatorsPath returns the path in root to the file recording the
+// isolators of the app with the given image ID for the enter helper, written
+// by stage1 along with the service of the app
+func AppIsolatorsPath(root string, imageID types.Hash) string {
+	return filepath.Join(Stage1RootfsPath(root), RelAppIsolatorsPath(imageID))
+}
+
+// RelAppIsolatorsPath returns the path of the file recording the isolators
+// of an app relative to the stage1 chroot
+func RelAppIsolatorsPath(imageID types.Hash) string {
+	return filepath.Join(isolatorsDir, imageID.String())
+}
+
+// NetInfoDir returns the directory in root recording the networks the
+// container has been attached to
+func NetInfoDir(root string) string {
+	return filepath.Join(root, "net")
+}
+
+// MDSSocketPath returns the path in root to the file recording the socket of
+// the metadata service the container is registered with, written by stage1
+// before registering it
+func MDSSocketPath(root string) string {
+	return filepath.Join(root, "mds-socket")
+}
+
 // AppImagePath returns the path where an app image (i.e. unpacked ACI) is rooted (i.e.
 // where its contents are extracted during stage0), based on the app image ID.
 func AppImagePath(root string, imageID types.Hash) string {
@@ -0,0 +1,36 @@
+package io
+
+import (
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// HashingReader is a tee reader hashing all the data read from R with H
+type HashingReader struct {
+	R io.Reader
+	H hash.Hash
+	N int64 // bytes read
+}
+
+// NewHashingReader returns a reader reading from r and hashing the data
+// with h
+func NewHashingReader(r io.Reader, h hash.Hash) *HashingReader {
+	return &HashingReader{R: r, H: h}
+}
+
+func (h *HashingReader) Read(p []byte) (int, error) {
+	n, err := h.R.Read(p)
+	if n > 0 {
+		h.H.Write(p[:n])
+		h.N += int64(n)
+	}
+	return n, err
+}
+
+// Drain reads and hashes what was left unread, for consumers which stop
+// before the end of the data, like tar readers
+func (h *HashingReader) Drain() error {
+	_, err := io.Copy(ioutil.Discard, h)
+	return err
+}
@@ -0,0 +1,29 @@
+package io
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"testing"
+)
+
+func TestHashingReader(t *testing.T) {
+	data := []byte("some data to hash")
+	h := NewHashingReader(bytes.NewReader(data), sha512.New())
+
+	// read part of it, and drain the rest
+	buf := make([]byte, 4)
+	if _, err := h.Read(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.Drain(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := sha512.Sum512(data)
+	if got := h.H.Sum(nil); !bytes.Equal(got, want[:]) {
+		t.Errorf("got hash %x, wanted %x", got, want)
+	}
+	if h.N != int64(len(data)) {
+		t.Errorf("got %d bytes, wanted %d", h.N, len(data))
+	}
+}
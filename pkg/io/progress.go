@@ -0,0 +1,112 @@
+package io
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/mitchellh/ioprogress"
+)
+
+// ProgressEvent reports how much of some data was read
+type ProgressEvent struct {
+	Name    string `json:"name"`
+	Current int64  `json:"current"`
+	Total   int64  `json:"total"` // -1 if unknown
+	Done    bool   `json:"done"`
+	// Err tells why the read failed, if done before the end
+	Err string `json:"error,omitempty"`
+}
+
+// ProgressFunc receives the progress of a read
+type ProgressFunc func(ProgressEvent)
+
+// ProgressReader reports the progress of reading Name from R, of size
+// Total, to Report: at most once every Interval, and once done.
+type ProgressReader struct {
+	R        io.Reader
+	Name     string
+	Total    int64
+	Interval time.Duration
+	Report   ProgressFunc
+
+	current  int64
+	lastDraw time.Time
+	done     bool
+	err      error
+}
+
+// NewProgressReader returns a reader reading name, of size total (-1 if
+// unknown), from r, reporting its progress to report every interval
+func NewProgressReader(r io.Reader, name string, total int64, interval time.Duration, report ProgressFunc) *ProgressReader {
+	return &ProgressReader{R: r, Name: name, Total: total, Interval: interval, Report: report}
+}
+
+func (p *ProgressReader) Read(b []byte) (int, error) {
+	n, err := p.R.Read(b)
+	p.current += int64(n)
+	if p.done {
+		return n, err
+	}
+	if err != nil {
+		p.done = true
+		if err != io.EOF {
+			p.err = err
+		}
+		p.report()
+	} else if time.Since(p.lastDraw) >= p.Interval {
+		p.report()
+	}
+	return n, err
+}
+
+func (p *ProgressReader) report() {
+	p.lastDraw = time.Now()
+	e := ProgressEvent{Name: p.Name, Current: p.current, Total: p.Total, Done: p.done}
+	if p.err != nil {
+		e.Err = p.err.Error()
+	}
+	p.Report(e)
+}
+
+// TerminalProgress returns a ProgressFunc drawing a progress bar on w,
+// which is assumed to be a terminal
+func TerminalProgress(w io.Writer) ProgressFunc {
+	const width = 80
+	fmtBytesSize := 18
+	var draw ioprogress.DrawFunc
+	return func(e ProgressEvent) {
+		if draw == nil {
+			prefix := e.Name
+			if max := width / 2; len(prefix) > max {
+				prefix = prefix[:max-3] + "..."
+			}
+			bar := ioprogress.DrawTextFormatBar(int64(width - len(prefix) - fmtBytesSize))
+			draw = ioprogress.DrawTerminalf(w, func(current, total int64) string {
+				if total <= 0 {
+					return fmt.Sprintf("%s: %s", prefix, ioprogress.DrawTextFormatBytes(current, current))
+				}
+				return fmt.Sprintf("%s: %s %s", prefix, bar(current, total), ioprogress.DrawTextFormatBytes(current, total))
+			})
+		}
+		total := e.Total
+		if total < 0 {
+			// -1 would end the bar
+			total = 0
+		}
+		draw(e.Current, total)
+		if e.Done {
+			draw(-1, -1)
+		}
+	}
+}
+
+// JSONProgress returns a ProgressFunc writing the events to w as lines of
+// JSON, for machines
+func JSONProgress(w io.Writer) ProgressFunc {
+	enc := json.NewEncoder(w)
+	return func(e ProgressEvent) {
+		enc.Encode(e)
+	}
+}
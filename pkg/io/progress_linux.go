@@ -0,0 +1,17 @@
+//go:build linux
+// +build linux
+
+package io
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// IsTerminal tells whether f is a terminal
+func IsTerminal(f *os.File) bool {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	return errno == 0
+}
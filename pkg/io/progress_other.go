@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package io
+
+import (
+	"os"
+)
+
+// IsTerminal tells whether f is a terminal, which is never assumed
+func IsTerminal(f *os.File) bool {
+	return false
+}
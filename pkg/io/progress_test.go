@@ -0,0 +1,117 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1000)
+
+	var events []ProgressEvent
+	report := func(e ProgressEvent) {
+		events = append(events, e)
+	}
+	// one byte at a time, with an interval too long to report more than
+	// the first read and the end
+	p := NewProgressReader(io.LimitReader(bytes.NewReader(data), 1000), "data", 1000, time.Hour, report)
+	buf := make([]byte, 1)
+	for {
+		if _, err := p.Read(buf); err != nil {
+			break
+		}
+	}
+	want := []ProgressEvent{
+		{Name: "data", Current: 1, Total: 1000},
+		{Name: "data", Current: 1000, Total: 1000, Done: true},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %+v, wanted %+v", events, want)
+	}
+
+	// reading past the end should not report again
+	p.Read(buf)
+	if len(events) != 2 {
+		t.Errorf("unexpected events: %+v", events[2:])
+	}
+}
+
+func TestProgressReaderError(t *testing.T) {
+	var events []ProgressEvent
+	report := func(e ProgressEvent) {
+		events = append(events, e)
+	}
+	failing := io.MultiReader(strings.NewReader("hello"), &errReader{errors.New("connection reset")})
+	p := NewProgressReader(failing, "data", 10, time.Hour, report)
+	if _, err := io.Copy(ioutil.Discard, p); err == nil {
+		t.Fatalf("expected error")
+	}
+	want := ProgressEvent{Name: "data", Current: 5, Total: 10, Done: true, Err: "connection reset"}
+	if last := events[len(events)-1]; last != want {
+		t.Errorf("got %+v, wanted %+v", last, want)
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestJSONProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := NewProgressReader(bytes.NewReader([]byte("hello")), "hello.aci", -1, 0, JSONProgress(&out))
+	if _, err := io.Copy(ioutil.Discard, p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var last ProgressEvent
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatalf("error decoding event: %v", err)
+	}
+	want := ProgressEvent{Name: "hello.aci", Current: 5, Total: -1, Done: true}
+	if last != want {
+		t.Errorf("got %+v, wanted %+v", last, want)
+	}
+}
+
+func TestTerminalProgress(t *testing.T) {
+	var out bytes.Buffer
+	draw := TerminalProgress(&out)
+	draw(ProgressEvent{Name: "a.aci", Current: 500, Total: 1000})
+	draw(ProgressEvent{Name: "a.aci", Current: 1000, Total: 1000, Done: true})
+	if s := out.String(); !strings.HasPrefix(s, "a.aci: [") || !strings.Contains(s, "1 KB/1 KB") || !strings.HasSuffix(s, "\r\n") {
+		t.Errorf("unexpected output: %q", s)
+	}
+
+	// unknown sizes should not break the bar
+	out.Reset()
+	draw = TerminalProgress(&out)
+	draw(ProgressEvent{Name: "a.aci", Current: 500, Total: -1, Done: true})
+	if s := out.String(); !strings.HasPrefix(s, "a.aci: 500 B/500 B") {
+		t.Errorf("unexpected output: %q", s)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpfile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if IsTerminal(f) {
+		t.Errorf("expected a file not to be a terminal")
+	}
+}
@@ -0,0 +1,60 @@
+package io
+
+import (
+	"io"
+	"time"
+)
+
+// RateLimitedReader reads from R at most Rate bytes per second on average,
+// as a token bucket holding at most a second worth of bytes: reads are
+// delayed once the bucket runs dry.
+type RateLimitedReader struct {
+	R    io.Reader
+	Rate int64
+
+	tokens float64
+	last   time.Time
+	// for tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewRateLimitedReader returns a reader reading from r at most rate bytes
+// per second on average, or without limit if rate is not positive
+func NewRateLimitedReader(r io.Reader, rate int64) *RateLimitedReader {
+	return &RateLimitedReader{
+		R:      r,
+		Rate:   rate,
+		tokens: float64(rate),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+func (l *RateLimitedReader) Read(p []byte) (int, error) {
+	if l.Rate <= 0 {
+		return l.R.Read(p)
+	}
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.Rate)
+		if l.tokens > float64(l.Rate) {
+			l.tokens = float64(l.Rate)
+		}
+	}
+	l.last = now
+
+	// a read can't take more than the bucket holds
+	if int64(len(p)) > l.Rate {
+		p = p[:l.Rate]
+	}
+	n, err := l.R.Read(p)
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		d := time.Duration(-l.tokens / float64(l.Rate) * float64(time.Second))
+		l.sleep(d)
+		l.tokens = 0
+		l.last = l.last.Add(d)
+	}
+	return n, err
+}
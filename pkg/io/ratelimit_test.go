@@ -0,0 +1,79 @@
+package io
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 10000)
+
+	// a fake clock, advanced by sleeping
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	l := NewRateLimitedReader(bytes.NewReader(data), 1000)
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	var reads []int
+	buf := make([]byte, 4096)
+	for {
+		n, err := l.Read(buf)
+		if n > 0 {
+			reads = append(reads, n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// no read should take more than a second worth of data
+	total := 0
+	for _, n := range reads {
+		if n > 1000 {
+			t.Errorf("unexpected read of %d bytes", n)
+		}
+		total += n
+	}
+	if total != len(data) {
+		t.Errorf("read %d bytes, wanted %d", total, len(data))
+	}
+	// the first second worth is in the bucket already
+	if slept != 9*time.Second {
+		t.Errorf("slept %v, wanted %v", slept, 9*time.Second)
+	}
+
+	// an idle reader should only save up to a second worth
+	now = now.Add(time.Hour)
+	slept = 0
+	l.R = bytes.NewReader(data)
+	if _, err := io.Copy(ioutil.Discard, l); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if slept != 9*time.Second {
+		t.Errorf("slept %v, wanted %v", slept, 9*time.Second)
+	}
+}
+
+func TestRateLimitedReaderUnlimited(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 10000)
+	for _, rate := range []int64{0, -1} {
+		l := NewRateLimitedReader(bytes.NewReader(data), rate)
+		l.sleep = func(d time.Duration) {
+			t.Errorf("rate %d: unexpected sleep of %v", rate, d)
+		}
+		n, err := io.Copy(ioutil.Discard, l)
+		if err != nil || n != int64(len(data)) {
+			t.Errorf("rate %d: read %d bytes (%v), wanted %d", rate, n, err, len(data))
+		}
+	}
+}
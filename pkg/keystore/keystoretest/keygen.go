@@ -1,3 +1,4 @@
+//go:build ignore
 // +build ignore
 
 // Generate opengpg keys for Application Container Keystore. Outputs to keymap.go
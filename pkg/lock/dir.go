@@ -3,19 +3,67 @@ package lock
 // Package lock implements simple locking primitives on a directory using flock
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"syscall"
+	"time"
 )
 
 var (
-	ErrLocked = errors.New("directory already locked")
+	ErrLocked   = errors.New("already locked")
+	ErrTimeout  = errors.New("timed out waiting for lock")
+	ErrCanceled = errors.New("canceled waiting for lock")
+	ErrLockLost = errors.New("lock lost, taken exclusively by another process")
 )
 
+const (
+	// pollInterval is how often a lock is retried when waiting for it
+	// with a timeout or cancellation, as flock(2) cannot be interrupted
+	pollInterval = 10 * time.Millisecond
+)
+
+// WaitError reports a lock which could not be taken in time, along with the
+// processes holding it when they could be found.
+type WaitError struct {
+	Path    string
+	Err     error // ErrTimeout or ErrCanceled
+	Holders []int
+}
+
+func (e *WaitError) Error() string {
+	if len(e.Holders) == 0 {
+		return fmt.Sprintf("%s: %v", e.Path, e.Err)
+	}
+	var pids []string
+	for _, pid := range e.Holders {
+		pids = append(pids, fmt.Sprint(pid))
+	}
+	return fmt.Sprintf("%s: %v, held by pid %s", e.Path, e.Err, strings.Join(pids, ", "))
+}
+
 // DirLock represents a Directory with an active Lock.
 type DirLock interface {
 	// Close() closes the file representing the lock, implicitly unlocking.
 	Close() error
 	// Fd() returns the fd number for the file representing the lock
 	Fd() (int, error)
+	// TryUpgrade() converts a shared lock to an exclusive one without
+	// blocking. It returns ErrLocked if another lock is held on the
+	// directory, and the lock is shared again. As the conversion is not
+	// atomic, another process may take the lock exclusively in between:
+	// ErrLockLost is returned then, and the lock is no longer held.
+	TryUpgrade() error
+	// Upgrade() converts a shared lock to an exclusive one, blocking
+	// until the other locks on the directory are released. The
+	// conversion is not atomic: another process may take the lock in
+	// between.
+	Upgrade() error
+	// Downgrade() converts an exclusive lock to a shared one.
+	Downgrade() error
+	// SetInheritable() chooses whether the file representing the lock is
+	// inherited by the programs exec'd, keeping them holding the lock.
+	// It is not by default.
+	SetInheritable(inherit bool) error
 }
 
 // TryExclusiveLock takes an exclusive lock on a directory without blocking.
@@ -25,14 +73,11 @@ func TryExclusiveLock(dir string) (DirLock, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = syscall.Flock(l.fd, syscall.LOCK_EX|syscall.LOCK_NB)
-	if err == syscall.EWOULDBLOCK {
-		err = ErrLocked
-	}
-	if err != nil {
+	if err := l.try(syscall.LOCK_EX); err != nil {
+		l.Close()
 		return nil, err
 	}
-	return l, err
+	return l, nil
 }
 
 // ExclusiveLock takes an exclusive lock on a directory.
@@ -55,11 +100,8 @@ func TrySharedLock(dir string) (DirLock, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = syscall.Flock(l.fd, syscall.LOCK_SH|syscall.LOCK_NB)
-	if err == syscall.EWOULDBLOCK {
-		err = ErrLocked
-	}
-	if err != nil {
+	if err := l.try(syscall.LOCK_SH); err != nil {
+		l.Close()
 		return nil, err
 	}
 	return l, nil
@@ -79,9 +121,85 @@ func SharedLock(dir string) (DirLock, error) {
 	return l, nil
 }
 
+// ExclusiveLockTimeout takes an exclusive lock on a directory, waiting at
+// most timeout for any lock already held on the directory.
+// It returns a *WaitError if the lock is not taken in time.
+func ExclusiveLockTimeout(dir string, timeout time.Duration) (DirLock, error) {
+	return lockWait(dir, syscall.LOCK_EX, time.After(timeout), nil)
+}
+
+// ExclusiveLockCancel takes an exclusive lock on a directory, waiting for
+// any lock already held on the directory until cancel is closed.
+// It returns a *WaitError if cancel is closed first.
+func ExclusiveLockCancel(dir string, cancel <-chan struct{}) (DirLock, error) {
+	return lockWait(dir, syscall.LOCK_EX, nil, cancel)
+}
+
+// SharedLockTimeout takes a co-operative (shared) lock on a directory,
+// waiting at most timeout for an exclusive lock held on the directory.
+// It returns a *WaitError if the lock is not taken in time.
+func SharedLockTimeout(dir string, timeout time.Duration) (DirLock, error) {
+	return lockWait(dir, syscall.LOCK_SH, time.After(timeout), nil)
+}
+
+// SharedLockCancel takes a co-operative (shared) lock on a directory,
+// waiting for an exclusive lock held on the directory until cancel is
+// closed.
+// It returns a *WaitError if cancel is closed first.
+func SharedLockCancel(dir string, cancel <-chan struct{}) (DirLock, error) {
+	return lockWait(dir, syscall.LOCK_SH, nil, cancel)
+}
+
+// lockWait takes a lock of kind how on a directory, retrying until it
+// succeeds, timeout fires or cancel is closed
+func lockWait(dir string, how int, timeout <-chan time.Time, cancel <-chan struct{}) (DirLock, error) {
+	l, err := newLock(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.wait(how, timeout, cancel); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
 type lock struct {
-	dir string
-	fd  int
+	path string
+	fd   int
+}
+
+// try takes a lock of kind how without blocking, returning ErrLocked if
+// another lock is in the way
+func (l *lock) try(how int) error {
+	err := syscall.Flock(l.fd, how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
+
+// wait takes a lock of kind how, retrying until it succeeds, timeout fires
+// or cancel is closed
+func (l *lock) wait(how int, timeout <-chan time.Time, cancel <-chan struct{}) error {
+	for {
+		err := syscall.Flock(l.fd, how|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+
+		select {
+		case <-time.After(pollInterval):
+			continue
+		case <-timeout:
+			err = ErrTimeout
+		case <-cancel:
+			err = ErrCanceled
+		}
+		// best effort
+		holders, _ := Holders(l.path)
+		return &WaitError{Path: l.path, Err: err, Holders: holders}
+	}
 }
 
 // Fd returns the lock's file descriptor
@@ -93,6 +211,48 @@ func (l *lock) Fd() (int, error) {
 	return l.fd, err
 }
 
+// TryUpgrade converts a shared lock to an exclusive one without blocking
+func (l *lock) TryUpgrade() error {
+	err := syscall.Flock(l.fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != syscall.EWOULDBLOCK {
+		return err
+	}
+	// flock(2) drops the shared lock before failing, so it may be gone
+	// for good
+	switch err := syscall.Flock(l.fd, syscall.LOCK_SH|syscall.LOCK_NB); err {
+	case nil:
+		return ErrLocked
+	case syscall.EWOULDBLOCK:
+		return ErrLockLost
+	default:
+		return err
+	}
+}
+
+// Upgrade converts a shared lock to an exclusive one
+func (l *lock) Upgrade() error {
+	return syscall.Flock(l.fd, syscall.LOCK_EX)
+}
+
+// Downgrade converts an exclusive lock to a shared one
+func (l *lock) Downgrade() error {
+	return syscall.Flock(l.fd, syscall.LOCK_SH)
+}
+
+// SetInheritable chooses whether the lock's file descriptor is inherited
+// across exec
+func (l *lock) SetInheritable(inherit bool) error {
+	flag := syscall.FD_CLOEXEC
+	if inherit {
+		flag = 0
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(l.fd), syscall.F_SETFD, uintptr(flag))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
 // Close closes the lock which implicitly unlocks it as well
 func (l *lock) Close() error {
 	fd := l.fd
@@ -102,10 +262,16 @@ func (l *lock) Close() error {
 
 // NewLock opens a new lock on a directory without acquisition
 func newLock(dir string) (*lock, error) {
-	l := &lock{dir: dir, fd: -1}
+	return openLock(dir, syscall.O_RDONLY|syscall.O_DIRECTORY)
+}
+
+// openLock opens a new lock on path, opened with flags, without acquisition.
+// The file is not inherited across exec until SetInheritable says so.
+func openLock(path string, flags int) (*lock, error) {
+	l := &lock{path: path, fd: -1}
 
-	// we can't use os.OpenFile as Go sets O_CLOEXEC
-	lfd, err := syscall.Open(l.dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	// we don't use os.OpenFile as the lock owns the fd
+	lfd, err := syscall.Open(l.path, flags|syscall.O_CLOEXEC, 0644)
 	if err != nil {
 		return nil, err
 	}
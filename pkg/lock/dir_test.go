@@ -1,9 +1,13 @@
 package lock
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewLock(t *testing.T) {
@@ -126,3 +130,159 @@ func TestSharedLock(t *testing.T) {
 		t.Fatalf("error creating lock: %v", err)
 	}
 }
+
+func TestLockTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.Remove(dir)
+
+	l, err := ExclusiveLock(dir)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+
+	// Both kinds of locks should time out, naming the holder
+	for _, lf := range []func(string, time.Duration) (DirLock, error){ExclusiveLockTimeout, SharedLockTimeout} {
+		_, err = lf(dir, 50*time.Millisecond)
+		werr, ok := err.(*WaitError)
+		if !ok || werr.Err != ErrTimeout {
+			t.Fatalf("expected timeout, got %v", err)
+		}
+		if !reflect.DeepEqual(werr.Holders, []int{os.Getpid()}) {
+			t.Errorf("unexpected holders: %v", werr.Holders)
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("held by pid %d", os.Getpid())) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	// Release the lock while waiting for it
+	go func(l DirLock) {
+		time.Sleep(50 * time.Millisecond)
+		l.Close()
+	}(l)
+	l, err = ExclusiveLockTimeout(dir, 10*time.Second)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	l.Close()
+}
+
+func TestLockCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.Remove(dir)
+
+	l, err := ExclusiveLock(dir)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	defer l.Close()
+
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(cancel)
+	}()
+	_, err = SharedLockCancel(dir, cancel)
+	if werr, ok := err.(*WaitError); !ok || werr.Err != ErrCanceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+
+	// An already canceled lock should still be taken if it is free
+	l.Close()
+	l, err = ExclusiveLockCancel(dir, cancel)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	l.Close()
+}
+
+func TestUpgradeDowngrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.Remove(dir)
+
+	l1, err := SharedLock(dir)
+	if err != nil {
+		t.Fatalf("error creating shared lock: %v", err)
+	}
+	defer l1.Close()
+	l2, err := TrySharedLock(dir)
+	if err != nil {
+		t.Fatalf("error creating shared lock: %v", err)
+	}
+
+	// Upgrading should fail while another shared lock is held
+	if err := l1.TryUpgrade(); err != ErrLocked {
+		t.Fatalf("expected upgrade to fail, got %v", err)
+	}
+	// but the lock should still be shared
+	if _, err := TryExclusiveLock(dir); err != ErrLocked {
+		t.Fatalf("expected exclusive lock to fail, got %v", err)
+	}
+	if err := l2.Close(); err != nil {
+		t.Fatalf("error closing lock: %v", err)
+	}
+
+	// Now upgrading should succeed
+	if err := l1.TryUpgrade(); err != nil {
+		t.Fatalf("error upgrading lock: %v", err)
+	}
+	if _, err := TrySharedLock(dir); err != ErrLocked {
+		t.Fatalf("expected shared lock to fail, got %v", err)
+	}
+
+	// And downgrading should let shared locks in again
+	if err := l1.Downgrade(); err != nil {
+		t.Fatalf("error downgrading lock: %v", err)
+	}
+	l3, err := TrySharedLock(dir)
+	if err != nil {
+		t.Fatalf("error creating shared lock: %v", err)
+	}
+
+	// Upgrade should block until the other shared lock is released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l3.Close()
+	}()
+	if err := l1.Upgrade(); err != nil {
+		t.Fatalf("error upgrading lock: %v", err)
+	}
+	if _, err := TrySharedLock(dir); err != ErrLocked {
+		t.Fatalf("expected shared lock to fail, got %v", err)
+	}
+}
+
+func TestLockPids(t *testing.T) {
+	locks := `1: POSIX  ADVISORY  WRITE 700 08:01:1311 0 EOF
+2: FLOCK  ADVISORY  READ  1234 08:01:1311 0 EOF
+2: -> FLOCK  ADVISORY  WRITE 5678 08:01:1311 0 EOF
+3: FLOCK  ADVISORY  READ  1235 08:01:1311 0 EOF
+4: FLOCK  ADVISORY  WRITE 1236 08:02:1311 0 EOF
+5: FLOCK  ADVISORY  WRITE 1237 103:01:1311 0 EOF
+`
+	pids, err := lockPids(strings.NewReader(locks), 8<<8|1, 1311)
+	if err != nil {
+		t.Fatalf("error parsing locks: %v", err)
+	}
+	if !reflect.DeepEqual(pids, []int{1234, 1235}) {
+		t.Errorf("unexpected pids: %v", pids)
+	}
+
+	// major 259, minor 1
+	pids, err = lockPids(strings.NewReader(locks), 259<<8|1, 1311)
+	if err != nil {
+		t.Fatalf("error parsing locks: %v", err)
+	}
+	if !reflect.DeepEqual(pids, []int{1237}) {
+		t.Errorf("unexpected pids: %v", pids)
+	}
+}
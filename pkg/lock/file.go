@@ -0,0 +1,81 @@
+package lock
+
+import (
+	"syscall"
+	"time"
+)
+
+// FileLock is a lock on a regular file, created if missing. Unlike the
+// locks on directories, it is opened before being taken, and can be
+// released and taken again.
+type FileLock struct {
+	*lock
+}
+
+// NewFileLock opens a lock on a file, creating it if missing, without
+// acquisition
+func NewFileLock(path string) (*FileLock, error) {
+	l, err := openLock(path, syscall.O_RDONLY|syscall.O_CREAT)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLock{l}, nil
+}
+
+// TryExclusiveLock takes an exclusive lock on the file without blocking.
+// It will return ErrLocked if any lock is already held on the file.
+func (l *FileLock) TryExclusiveLock() error {
+	return l.try(syscall.LOCK_EX)
+}
+
+// ExclusiveLock takes an exclusive lock on the file.
+// It will block if any lock is already held on the file.
+func (l *FileLock) ExclusiveLock() error {
+	return syscall.Flock(l.fd, syscall.LOCK_EX)
+}
+
+// ExclusiveLockTimeout takes an exclusive lock on the file, waiting at most
+// timeout for any lock already held on the file.
+// It returns a *WaitError if the lock is not taken in time.
+func (l *FileLock) ExclusiveLockTimeout(timeout time.Duration) error {
+	return l.wait(syscall.LOCK_EX, time.After(timeout), nil)
+}
+
+// ExclusiveLockCancel takes an exclusive lock on the file, waiting for any
+// lock already held on the file until cancel is closed.
+// It returns a *WaitError if cancel is closed first.
+func (l *FileLock) ExclusiveLockCancel(cancel <-chan struct{}) error {
+	return l.wait(syscall.LOCK_EX, nil, cancel)
+}
+
+// TrySharedLock takes a co-operative (shared) lock on the file without
+// blocking.
+// It will return ErrLocked if an exclusive lock already exists on the file.
+func (l *FileLock) TrySharedLock() error {
+	return l.try(syscall.LOCK_SH)
+}
+
+// SharedLock takes a co-operative (shared) lock on the file.
+// It will block if an exclusive lock is already held on the file.
+func (l *FileLock) SharedLock() error {
+	return syscall.Flock(l.fd, syscall.LOCK_SH)
+}
+
+// SharedLockTimeout takes a co-operative (shared) lock on the file, waiting
+// at most timeout for an exclusive lock held on the file.
+// It returns a *WaitError if the lock is not taken in time.
+func (l *FileLock) SharedLockTimeout(timeout time.Duration) error {
+	return l.wait(syscall.LOCK_SH, time.After(timeout), nil)
+}
+
+// SharedLockCancel takes a co-operative (shared) lock on the file, waiting
+// for an exclusive lock held on the file until cancel is closed.
+// It returns a *WaitError if cancel is closed first.
+func (l *FileLock) SharedLockCancel(cancel <-chan struct{}) error {
+	return l.wait(syscall.LOCK_SH, nil, cancel)
+}
+
+// Unlock releases the lock, keeping the file open
+func (l *FileLock) Unlock() error {
+	return syscall.Flock(l.fd, syscall.LOCK_UN)
+}
@@ -0,0 +1,118 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+
+	// The file should be created
+	l1, err := NewFileLock(path)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	defer l1.Close()
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		t.Fatalf("expected lock file to be created: %v", err)
+	}
+	l2, err := NewFileLock(path)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	defer l2.Close()
+
+	// Set up the initial exclusive lock
+	if err := l1.ExclusiveLock(); err != nil {
+		t.Fatalf("error taking lock: %v", err)
+	}
+
+	// Now try another lock, should fail
+	if err := l2.TrySharedLock(); err != ErrLocked {
+		t.Fatalf("expected shared lock to fail, got %v", err)
+	}
+	if err := l2.ExclusiveLockTimeout(50 * time.Millisecond); err == nil {
+		t.Fatalf("expected exclusive lock to time out")
+	}
+
+	// Downgrading should let shared locks in
+	if err := l1.Downgrade(); err != nil {
+		t.Fatalf("error downgrading lock: %v", err)
+	}
+	if err := l2.TrySharedLock(); err != nil {
+		t.Fatalf("error taking lock: %v", err)
+	}
+	if err := l2.Unlock(); err != nil {
+		t.Fatalf("error releasing lock: %v", err)
+	}
+
+	// Unlock the original lock, keeping it open
+	if err := l1.Unlock(); err != nil {
+		t.Fatalf("error releasing lock: %v", err)
+	}
+	if err := l2.TryExclusiveLock(); err != nil {
+		t.Fatalf("error taking lock: %v", err)
+	}
+	if err := l1.TryExclusiveLock(); err != ErrLocked {
+		t.Fatalf("expected exclusive lock to fail, got %v", err)
+	}
+}
+
+func TestSetInheritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dl, err := TryExclusiveLock(dir)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	defer dl.Close()
+	fl, err := NewFileLock(filepath.Join(dir, "lock"))
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	defer fl.Close()
+
+	cloexec := func(l DirLock) bool {
+		fd, err := l.Fd()
+		if err != nil {
+			t.Fatalf("error getting fd: %v", err)
+		}
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno != 0 {
+			t.Fatalf("error getting fd flags: %v", errno)
+		}
+		return flags&syscall.FD_CLOEXEC != 0
+	}
+	for _, l := range []DirLock{dl, fl} {
+		// Locks should not be inherited by default
+		if !cloexec(l) {
+			t.Errorf("expected lock not to be inherited")
+		}
+		if err := l.SetInheritable(true); err != nil {
+			t.Fatalf("error setting lock inheritable: %v", err)
+		}
+		if cloexec(l) {
+			t.Errorf("expected lock to be inherited")
+		}
+		if err := l.SetInheritable(false); err != nil {
+			t.Fatalf("error setting lock not inheritable: %v", err)
+		}
+		if !cloexec(l) {
+			t.Errorf("expected lock not to be inherited")
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package lock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/rocket/pkg/proc"
+)
+
+const procLocks = "/proc/locks"
+
+// Holders returns the pids of the processes holding a lock on a directory,
+// as best it can: /proc/locks names the process which took a lock, which
+// may have exited after passing it on to its children. Those are then
+// looked for among the processes having the directory open.
+func Holders(dir string) ([]int, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(procLocks)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pids, err := lockPids(f, uint64(st.Dev), st.Ino)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", procLocks, err)
+	}
+
+	var holders []int
+	passed := false
+	for _, pid := range pids {
+		if _, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid))); err != nil {
+			passed = true
+			continue
+		}
+		holders = append(holders, pid)
+	}
+	if !passed {
+		return holders, nil
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return holders, err
+	}
+	u, err := proc.LiveProcs(abs)
+	if err != nil {
+		return holders, err
+	}
+	for _, pid := range u.ByPath[abs] {
+		holders = append(holders, pid)
+	}
+	return uniq(holders), nil
+}
+
+// lockPids returns the pids holding flock locks on the file with the given
+// device and inode, as listed in /proc/locks:
+//
+//	1: FLOCK  ADVISORY  WRITE 1234 08:01:1311 0 EOF
+//	1: -> FLOCK  ADVISORY  WRITE 5678 08:01:1311 0 EOF
+//
+// where the second line is a process waiting for the lock.
+func lockPids(r io.Reader, dev, ino uint64) ([]int, error) {
+	major, minor := (dev>>8)&0xfff|(dev>>32)&^0xfff, dev&0xff|(dev>>12)&^0xff
+	id := fmt.Sprintf("%02x:%02x:%d", major, minor, ino)
+
+	var pids []int
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fs := strings.Fields(s.Text())
+		if len(fs) < 6 || fs[1] != "FLOCK" || fs[5] != id {
+			continue
+		}
+		pid, err := strconv.Atoi(fs[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q", fs[4])
+		}
+		pids = append(pids, pid)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return uniq(pids), nil
+}
+
+// uniq sorts pids and removes duplicates
+func uniq(pids []int) []int {
+	sort.Ints(pids)
+	var u []int
+	for i, pid := range pids {
+		if i == 0 || pid != pids[i-1] {
+			u = append(u, pid)
+		}
+	}
+	return u
+}
@@ -0,0 +1,73 @@
+package lock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// KeyLock hands out locks on arbitrary string keys, such as the keys of a
+// store, backed by one file per key in a lock directory. The files are
+// never removed, as that would race with processes about to lock them.
+type KeyLock struct {
+	dir string
+}
+
+// NewKeyLock returns a KeyLock keeping its lock files in dir, which is
+// created if missing
+func NewKeyLock(dir string) (*KeyLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &KeyLock{dir: dir}, nil
+}
+
+// keyPath returns the path of the lock file of key; keys are hashed as they
+// may be too long, or hold any character
+func (k *KeyLock) keyPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(k.dir, hex.EncodeToString(sum[:]))
+}
+
+// Lock opens the lock on key without acquisition
+func (k *KeyLock) Lock(key string) (*FileLock, error) {
+	return NewFileLock(k.keyPath(key))
+}
+
+// TryExclusiveLock takes an exclusive lock on key without blocking.
+// It will return ErrLocked if any lock is already held on key.
+func (k *KeyLock) TryExclusiveLock(key string) (*FileLock, error) {
+	return k.acquire(key, (*FileLock).TryExclusiveLock)
+}
+
+// ExclusiveLock takes an exclusive lock on key.
+// It will block if any lock is already held on key.
+func (k *KeyLock) ExclusiveLock(key string) (*FileLock, error) {
+	return k.acquire(key, (*FileLock).ExclusiveLock)
+}
+
+// TrySharedLock takes a co-operative (shared) lock on key without blocking.
+// It will return ErrLocked if an exclusive lock already exists on key.
+func (k *KeyLock) TrySharedLock(key string) (*FileLock, error) {
+	return k.acquire(key, (*FileLock).TrySharedLock)
+}
+
+// SharedLock takes a co-operative (shared) lock on key.
+// It will block if an exclusive lock is already held on key.
+func (k *KeyLock) SharedLock(key string) (*FileLock, error) {
+	return k.acquire(key, (*FileLock).SharedLock)
+}
+
+// acquire opens the lock on key and takes it with take
+func (k *KeyLock) acquire(key string, take func(*FileLock) error) (*FileLock, error) {
+	l, err := k.Lock(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := take(l); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
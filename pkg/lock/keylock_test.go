@@ -0,0 +1,69 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The lock directory should be created
+	kl, err := NewKeyLock(filepath.Join(dir, "locks"))
+	if err != nil {
+		t.Fatalf("error creating key lock: %v", err)
+	}
+
+	const key1 = "sha512-0c45e8c0ab2"
+	const key2 = "https://example.com/images/app-1.0.0-linux-amd64.aci?with=/../slashes"
+
+	// Set up the initial exclusive lock
+	l1, err := kl.ExclusiveLock(key1)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+
+	// Now try another lock on the same key, should fail
+	if _, err := kl.TrySharedLock(key1); err != ErrLocked {
+		t.Fatalf("expected shared lock to fail, got %v", err)
+	}
+
+	// But other keys should be independent
+	l2, err := kl.TryExclusiveLock(key2)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+
+	// Each key should have its own file in the lock directory
+	ls, err := ioutil.ReadDir(filepath.Join(dir, "locks"))
+	if err != nil {
+		t.Fatalf("error reading lock directory: %v", err)
+	}
+	if len(ls) != 2 {
+		t.Errorf("unexpected number of lock files: %d, wanted 2", len(ls))
+	}
+
+	// Unlock the original lock
+	if err := l1.Close(); err != nil {
+		t.Fatalf("error closing lock: %v", err)
+	}
+	l3, err := kl.TrySharedLock(key1)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	l4, err := kl.SharedLock(key1)
+	if err != nil {
+		t.Fatalf("error creating lock: %v", err)
+	}
+	for _, l := range []*FileLock{l2, l3, l4} {
+		if err := l.Close(); err != nil {
+			t.Fatalf("error closing lock: %v", err)
+		}
+	}
+}
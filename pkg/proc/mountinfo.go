@@ -0,0 +1,127 @@
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const mountinfo = "/proc/self/mountinfo"
+
+// Mount is a mount of the calling process' mount namespace, as described in
+// /proc/self/mountinfo
+type Mount struct {
+	ID         int
+	Parent     int
+	Dev        string // major:minor of the mounted filesystem
+	Root       string // directory of the filesystem mounted, for bind mounts
+	MountPoint string
+}
+
+// Mounts returns the mounts of the calling process' mount namespace
+func Mounts() ([]Mount, error) {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountinfo(f)
+}
+
+// parseMountinfo parses the /proc/*/mountinfo format (`man 5 proc`)
+func parseMountinfo(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Lines are of the form:
+		//    id parent major:minor root mountpoint options [optional...] - fstype source superoptions
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 5 {
+			return nil, fmt.Errorf("malformed mountinfo line %q", scanner.Text())
+		}
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("malformed mount id %q", parts[0])
+		}
+		parent, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed parent mount id %q", parts[1])
+		}
+		mounts = append(mounts, Mount{
+			ID:         id,
+			Parent:     parent,
+			Dev:        parts[2],
+			Root:       unescapeMountinfo(parts[3]),
+			MountPoint: unescapeMountinfo(parts[4]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// unescapeMountinfo decodes the \ooo escapes of paths in mountinfo
+func unescapeMountinfo(p string) string {
+	var b []byte
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+4 <= len(p) {
+			if c, err := strconv.ParseUint(p[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(c))
+				i += 3
+				continue
+			}
+		}
+		b = append(b, p[i])
+	}
+	return string(b)
+}
+
+// hasPathPrefix tells whether p is dir or is below it
+func hasPathPrefix(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// MountsUnder returns the mounts among ms whose mount point is at or below
+// dir, which must be absolute
+func MountsUnder(ms []Mount, dir string) []Mount {
+	var under []Mount
+	for _, m := range ms {
+		if hasPathPrefix(m.MountPoint, dir) {
+			under = append(under, m)
+		}
+	}
+	return under
+}
+
+// BindsFrom returns the mounts among ms, mounted outside of dir, which bind
+// a directory at or below dir, which must be absolute
+func BindsFrom(ms []Mount, dir string) []Mount {
+	// find the filesystem holding dir, and where dir is within it
+	var fs *Mount
+	for i, m := range ms {
+		if hasPathPrefix(dir, m.MountPoint) && (fs == nil || len(m.MountPoint) >= len(fs.MountPoint)) {
+			fs = &ms[i]
+		}
+	}
+	if fs == nil {
+		return nil
+	}
+	rel, err := filepath.Rel(fs.MountPoint, dir)
+	if err != nil {
+		return nil
+	}
+	root := filepath.Join(fs.Root, rel)
+
+	var binds []Mount
+	for _, m := range ms {
+		if m.Dev == fs.Dev && m.ID != fs.ID && hasPathPrefix(m.Root, root) && !hasPathPrefix(m.MountPoint, dir) {
+			binds = append(binds, m)
+		}
+	}
+	return binds
+}
@@ -0,0 +1,65 @@
+package proc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMountinfo = `15 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+16 15 0:4 / /proc rw,nosuid - proc proc rw
+17 15 8:2 / /var rw,relatime shared:2 - ext4 /dev/sda2 rw
+30 17 8:2 /lib/rkt/containers/c1/stage1/opt/stage2/app/rootfs/data /srv/data rw - ext4 /dev/sda2 rw
+31 17 0:30 / /var/lib/rkt/containers/c1/stage1/proc rw - proc proc rw
+32 15 8:2 /lib/rkt/containers/c10 /mnt/other rw - ext4 /dev/sda2 rw
+33 15 8:1 /srv/with\040space /mnt/space rw - ext4 /dev/sda1 rw
+`
+
+func TestParseMountinfo(t *testing.T) {
+	ms, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatalf("error parsing mountinfo: %v", err)
+	}
+	if len(ms) != 7 {
+		t.Fatalf("got %d mounts, wanted 7", len(ms))
+	}
+	want := Mount{ID: 33, Parent: 15, Dev: "8:1", Root: "/srv/with space", MountPoint: "/mnt/space"}
+	if !reflect.DeepEqual(ms[6], want) {
+		t.Errorf("got %+v, wanted %+v", ms[6], want)
+	}
+
+	if _, err := parseMountinfo(strings.NewReader("15 0 8:1\n")); err == nil {
+		t.Errorf("expected error parsing malformed mountinfo")
+	}
+}
+
+func TestMountsUnderBindsFrom(t *testing.T) {
+	ms, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatalf("error parsing mountinfo: %v", err)
+	}
+	ids := func(ms []Mount) []int {
+		var ids []int
+		for _, m := range ms {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	dir := "/var/lib/rkt/containers/c1"
+	if got := ids(MountsUnder(ms, dir)); !reflect.DeepEqual(got, []int{31}) {
+		t.Errorf("mounts under %s: got %v, wanted [31]", dir, got)
+	}
+	if got := ids(BindsFrom(ms, dir)); !reflect.DeepEqual(got, []int{30}) {
+		t.Errorf("binds from %s: got %v, wanted [30]", dir, got)
+	}
+
+	dir = "/var/lib/rkt/containers/c10"
+	if got := ids(BindsFrom(ms, dir)); !reflect.DeepEqual(got, []int{32}) {
+		t.Errorf("binds from %s: got %v, wanted [32]", dir, got)
+	}
+	dir = "/var/lib/rkt/containers/c2"
+	if got := ids(BindsFrom(ms, dir)); got != nil {
+		t.Errorf("binds from %s: got %v, wanted none", dir, got)
+	}
+}
@@ -2,20 +2,17 @@ package proc
 
 import (
 	"bufio"
-	"errors"
 	"io"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 var (
-	ErrNotRoot = errors.New("must be root to access all process information")
-
 	// socket symlinks in /proc/<pid>/fds take the form:
 	// 	type:[inode]
 	sre = regexp.MustCompile(`^([[:alpha:]]+):\[([[:digit:]]+)\]$`)
@@ -25,105 +22,157 @@ var (
 )
 
 const (
-	procfs    = "/proc/"
-	unixSocks = "/proc/net/unix"
+	procfs = "/proc"
 )
 
-// LiveProcs is similar to `man 1 fuser`; it takes a prefix and returns a map
-// of PIDs of any processes accessing files with the prefix.
+// Usage describes which processes access which files
+type Usage struct {
+	// ByPid maps the PIDs of the processes to the files they access
+	ByPid map[int][]string
+	// ByPath maps the files to the PIDs of the processes accessing them
+	ByPath map[string][]int
+	// Complete is false if some processes could not be inspected, which
+	// happens to callers which are not root
+	Complete bool
+}
+
+// LiveProcs is similar to `man 1 fuser`; it takes a prefix and returns the
+// processes accessing files with the prefix, other than the caller.
 // A process is considered to be accessing a file if it has an open file
 // descriptor directly referencing the file, has an open Unix socket
-// referencing a file, or has a file mapped into memory.
+// referencing a file, has a file mapped into memory, or has the file as its
+// working directory, root directory or executable.
+// Processes which the caller may not inspect are skipped, and the result
+// marked as incomplete.
 // This operation is inherently racy (both false positives and false negatives
 // are possible) and hence this should be considered an approximation only.
-// TODO(jonboulle): map filename(string) -> []int(pids) instead
-func LiveProcs(prefix string) (map[int][]string, error) {
-	if os.Getegid() != 0 {
-		return nil, ErrNotRoot
+func LiveProcs(prefix string) (*Usage, error) {
+	return liveProcs(procfs, prefix, os.Getpid())
+}
+
+// liveProcs implements LiveProcs on the procfs mounted at root, skipping the
+// process self
+func liveProcs(root string, prefix string, self int) (*Usage, error) {
+	u := &Usage{
+		ByPid:    make(map[int][]string),
+		ByPath:   make(map[string][]int),
+		Complete: true,
 	}
-	skts, err := unixSocketsWithPrefix(prefix)
+
+	skts, err := unixSocketsWithPrefix(filepath.Join(root, "net/unix"), prefix)
 	if err != nil {
 		return nil, err
 	}
-	ps, err := ioutil.ReadDir(procfs)
+	ps, err := ioutil.ReadDir(root)
 	if err != nil {
 		return nil, err
 	}
-	pids := make(map[int][]string)
-	self := os.Getpid()
+
 	for _, p := range ps {
 		pid, err := strconv.Atoi(p.Name())
-		if err != nil {
-			continue
-		}
-		if pid == self {
+		if err != nil || pid == self {
 			continue
 		}
-
-		// Parse file descriptors
-		pdir := filepath.Join(procfs, p.Name(), "fd")
-		fds, err := ioutil.ReadDir(pdir)
+		pdir := filepath.Join(root, p.Name())
+		paths, err := procPathsWithPrefix(pdir, prefix, skts)
 		switch {
 		case err == nil:
 		case os.IsNotExist(err):
 			// assume we're too late
 			continue
+		case os.IsPermission(err):
+			u.Complete = false
 		default:
 			return nil, err
 		}
-		links := make([]string, len(fds))
-		for i, fd := range fds {
-			links[i] = path.Join(pdir, fd.Name())
+		for _, path := range paths {
+			u.add(pid, path)
 		}
+	}
 
-		for _, path := range fdsWithPrefix(links, prefix, skts) {
-			if _, ok := pids[pid]; ok {
-				pids[pid] = make([]string, 0)
-			}
-			pids[pid] = append(pids[pid], path)
-		}
+	for pid := range u.ByPid {
+		sort.Strings(u.ByPid[pid])
+	}
+	for path := range u.ByPath {
+		sort.Ints(u.ByPath[path])
+	}
+	return u, nil
+}
 
-		// Parse maps
-		mfile := filepath.Join(procfs, p.Name(), "maps")
-		mfh, err := os.Open(mfile)
-		switch {
-		case err == nil:
-		case os.IsNotExist(err):
-			// assume we're too late
-			continue
-		default:
-			return nil, err
+// add records that pid accesses path, unless already known
+func (u *Usage) add(pid int, path string) {
+	for _, p := range u.ByPid[pid] {
+		if p == path {
+			return
 		}
-		paths, err := mmapsWithPrefix(mfh, prefix)
+	}
+	u.ByPid[pid] = append(u.ByPid[pid], path)
+	u.ByPath[path] = append(u.ByPath[path], pid)
+}
+
+// procPathsWithPrefix returns the paths with the given prefix accessed by the
+// process whose procfs directory is pdir. A permission error is returned
+// along with what could be inspected nevertheless.
+func procPathsWithPrefix(pdir string, pre string, skts map[string]string) ([]string, error) {
+	var paths []string
+	var perr error
+
+	// Parse working directory, root directory and executable
+	var links []string
+	for _, l := range []string{"cwd", "root", "exe"} {
+		links = append(links, filepath.Join(pdir, l))
+	}
+
+	// Parse file descriptors
+	fdir := filepath.Join(pdir, "fd")
+	fds, err := ioutil.ReadDir(fdir)
+	switch {
+	case err == nil:
+		for _, fd := range fds {
+			links = append(links, filepath.Join(fdir, fd.Name()))
+		}
+	case os.IsPermission(err):
+		perr = err
+	default:
+		return nil, err
+	}
+	lpaths, err := linksWithPrefix(links, pre, skts)
+	if err != nil {
+		perr = err
+	}
+	paths = append(paths, lpaths...)
+
+	// Parse maps
+	mfh, err := os.Open(filepath.Join(pdir, "maps"))
+	switch {
+	case err == nil:
+		mpaths, err := mmapsWithPrefix(mfh, pre)
 		mfh.Close()
 		if err != nil {
 			return nil, err
 		}
-		for _, path := range paths {
-			if _, ok := pids[pid]; ok {
-				pids[pid] = make([]string, 0)
-			}
-			pids[pid] = append(pids[pid], path)
-		}
-
+		paths = append(paths, mpaths...)
+	case os.IsPermission(err):
+		perr = err
+	default:
+		return nil, err
 	}
-	return pids, nil
+
+	return paths, perr
 }
 
 // unixSocketsWithPrefix returns a map (of inode->path) describing the Unix
-// sockets open on the system accessing any paths with the given prefix
-func unixSocketsWithPrefix(pre string) (map[string]string, error) {
+// sockets listed in the file at unixSocks (in the /proc/net/unix format)
+// accessing any paths with the given prefix
+func unixSocketsWithPrefix(unixSocks string, pre string) (map[string]string, error) {
 	fh, err := os.Open(unixSocks)
-	defer fh.Close()
 	if err != nil {
 		return nil, err
 	}
+	defer fh.Close()
 	socks := make(map[string]string)
 	scanner := bufio.NewScanner(fh)
 	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
 		line := scanner.Text()
 		parts := strings.Fields(line)
 		if len(parts) != 8 {
@@ -138,21 +187,29 @@ func unixSocketsWithPrefix(pre string) (map[string]string, error) {
 			socks[inode] = pathname
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 	return socks, nil
 }
 
-// fdsWithPrefix takes a list of fds (which should represent absolute paths to
-// file descriptor links, i.e. as in /proc/<pid>/fd/), determines which files
-// the fds represent, and returns a subset of those files that have the given
-// prefix.
-func fdsWithPrefix(fds []string, pre string, socks map[string]string) []string {
+// linksWithPrefix takes a list of links (which should represent absolute
+// paths to links in /proc/<pid>/, e.g. file descriptors in /proc/<pid>/fd/),
+// determines which files the links represent, and returns a subset of those
+// files that have the given prefix. Links which may not be read are skipped,
+// and a permission error returned along with the files found.
+func linksWithPrefix(links []string, pre string, socks map[string]string) ([]string, error) {
 	var paths []string
-	for _, fd := range fds {
-		dest, err := os.Readlink(fd)
+	var perr error
+	for _, l := range links {
+		dest, err := os.Readlink(l)
 		if err != nil {
+			if os.IsPermission(err) {
+				perr = err
+			}
 			continue
 		}
-		// Simple case: fd directly references a file we're interested in
+		// Simple case: link directly references a file we're interested in
 		if strings.HasPrefix(dest, pre) {
 			paths = append(paths, dest)
 		}
@@ -166,7 +223,7 @@ func fdsWithPrefix(fds []string, pre string, socks map[string]string) []string {
 			}
 		}
 	}
-	return paths
+	return paths, perr
 }
 
 // mmapsWithPrefix takes a Reader which should represent a file in the
@@ -176,9 +233,6 @@ func mmapsWithPrefix(r io.Reader, pre string) ([]string, error) {
 	var paths []string
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
 		line := scanner.Text()
 		parts := strings.Fields(line)
 		// Lines are of the form:
@@ -192,5 +246,8 @@ func mmapsWithPrefix(r io.Reader, pre string) ([]string, error) {
 			paths = append(paths, pathname)
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 	return paths, nil
 }
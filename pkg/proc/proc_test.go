@@ -0,0 +1,180 @@
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeProc describes a process of a fake procfs
+type fakeProc struct {
+	links map[string]string // by path relative to the process' dir
+	maps  string
+}
+
+// writeFakeProcfs creates a fake procfs in root
+func writeFakeProcfs(t *testing.T, root string, procs map[string]fakeProc, unix string) {
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "net/unix"), []byte(unix), 0644); err != nil {
+		t.Fatalf("error writing net/unix: %v", err)
+	}
+	for pid, p := range procs {
+		pdir := filepath.Join(root, pid)
+		if err := os.MkdirAll(filepath.Join(pdir, "fd"), 0755); err != nil {
+			t.Fatalf("error creating dir: %v", err)
+		}
+		for l, dest := range p.links {
+			if err := os.Symlink(dest, filepath.Join(pdir, l)); err != nil {
+				t.Fatalf("error creating link: %v", err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(pdir, "maps"), []byte(p.maps), 0644); err != nil {
+			t.Fatalf("error writing maps: %v", err)
+		}
+	}
+}
+
+func TestLiveProcs(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	const c = "/var/lib/rkt/containers/c1"
+	writeFakeProcfs(t, root, map[string]fakeProc{
+		// several fds and a mapping of the same file
+		"10": {
+			links: map[string]string{
+				"fd/0":  "/dev/null",
+				"fd/3":  c + "/stage1/rootfs/lib/libc.so",
+				"fd/4":  c + "/stage1/rootfs/lib/libc.so",
+				"fd/5":  "socket:[1234]",
+				"fd/6":  "socket:[999]",
+				"cwd":   "/",
+				"root":  "/",
+				"exe":   "/usr/bin/foo",
+				"fd/10": "pipe:[42]",
+			},
+			maps: "7f0000000000-7f0000001000 r-xp 00000000 08:01 1 " + c + "/stage1/rootfs/lib/libc.so\n" +
+				"7f0000001000-7f0000002000 rw-p 00000000 00:00 0\n",
+		},
+		// another process on the same file, listed after the others by
+		// ReadDir
+		"5": {
+			links: map[string]string{"fd/7": c + "/stage1/rootfs/lib/libc.so"},
+		},
+		// cwd, root and exe
+		"20": {
+			links: map[string]string{
+				"cwd":  c + "/stage1/rootfs/tmp",
+				"root": c + "/stage1/rootfs",
+				"exe":  c + "/stage1/rootfs/bin/sh",
+			},
+		},
+		// unrelated
+		"30": {
+			links: map[string]string{"cwd": "/home", "root": "/", "exe": "/bin/bash", "fd/0": "/dev/tty"},
+		},
+		// ourselves
+		"40": {
+			links: map[string]string{"fd/3": c},
+		},
+		// not a process
+		"self": {},
+	}, "Num       RefCount Protocol Flags    Type St Inode Path\n"+
+		"0000000000000000: 00000002 00000000 00010000 0001 01 1234 "+c+"/stage1/rootfs/run/sock\n"+
+		"0000000000000000: 00000002 00000000 00010000 0001 01 999 /run/other.sock\n")
+
+	u, err := liveProcs(root, c, 40)
+	if err != nil {
+		t.Fatalf("error getting live processes: %v", err)
+	}
+	if !u.Complete {
+		t.Errorf("expected complete result")
+	}
+	wantByPid := map[int][]string{
+		5:  {c + "/stage1/rootfs/lib/libc.so"},
+		10: {c + "/stage1/rootfs/lib/libc.so", c + "/stage1/rootfs/run/sock"},
+		20: {c + "/stage1/rootfs", c + "/stage1/rootfs/bin/sh", c + "/stage1/rootfs/tmp"},
+	}
+	if !reflect.DeepEqual(u.ByPid, wantByPid) {
+		t.Errorf("by pid: got %v, wanted %v", u.ByPid, wantByPid)
+	}
+	wantByPath := map[string][]int{
+		c + "/stage1/rootfs/lib/libc.so": {5, 10},
+		c + "/stage1/rootfs/run/sock":    {10},
+		c + "/stage1/rootfs":             {20},
+		c + "/stage1/rootfs/bin/sh":      {20},
+		c + "/stage1/rootfs/tmp":         {20},
+	}
+	if !reflect.DeepEqual(u.ByPath, wantByPath) {
+		t.Errorf("by path: got %v, wanted %v", u.ByPath, wantByPath)
+	}
+
+	// several processes on the same file
+	u, err = liveProcs(root, c+"/stage1/rootfs/lib", 0)
+	if err != nil {
+		t.Fatalf("error getting live processes: %v", err)
+	}
+	if got := u.ByPath[c+"/stage1/rootfs/lib/libc.so"]; !reflect.DeepEqual(got, []int{5, 10}) {
+		t.Errorf("got %v, wanted [5 10]", got)
+	}
+	u, err = liveProcs(root, c, 0)
+	if err != nil {
+		t.Fatalf("error getting live processes: %v", err)
+	}
+	if got := u.ByPath[c]; !reflect.DeepEqual(got, []int{40}) {
+		t.Errorf("got %v, wanted [40]", got)
+	}
+}
+
+func TestLiveProcsPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFakeProcfs(t, root, map[string]fakeProc{
+		"10": {links: map[string]string{"cwd": "/c/tmp"}},
+		"20": {links: map[string]string{"cwd": "/c/tmp"}},
+	}, "")
+	// like the fds of the processes of other users
+	if err := os.Chmod(filepath.Join(root, "20/fd"), 0); err != nil {
+		t.Fatalf("error changing mode: %v", err)
+	}
+	defer os.Chmod(filepath.Join(root, "20/fd"), 0755)
+
+	u, err := liveProcs(root, "/c", 0)
+	if err != nil {
+		t.Fatalf("error getting live processes: %v", err)
+	}
+	if u.Complete {
+		t.Errorf("expected incomplete result")
+	}
+	if got := u.ByPath["/c/tmp"]; !reflect.DeepEqual(got, []int{10, 20}) {
+		t.Errorf("got %v, wanted [10 20]", got)
+	}
+}
+
+func TestMmapsWithPrefix(t *testing.T) {
+	maps := "00400000-0040b000 r-xp 00000000 08:01 1 /usr/bin/cat\n" +
+		"7f0000000000-7f0000001000 r--p 00000000 08:01 2 /c/lib/ld.so\n" +
+		"7ffd00000000-7ffd00021000 rw-p 00000000 00:00 0 [stack]\n"
+	paths, err := mmapsWithPrefix(strings.NewReader(maps), "/c/")
+	if err != nil {
+		t.Fatalf("error parsing maps: %v", err)
+	}
+	if !reflect.DeepEqual(paths, []string{"/c/lib/ld.so"}) {
+		t.Errorf("got %v", paths)
+	}
+}
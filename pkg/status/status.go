@@ -0,0 +1,118 @@
+// Package status reads and writes what is recorded in the directory of a
+// container as it goes through its life: that stage0 handed it over to
+// stage1, the pid of the container's init, and the exit status of each app
+// once it has exited. The files are replaced atomically, so they are never
+// seen partially written.
+package status
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/path"
+)
+
+// State of a container that is not running, as recorded in its directory
+type State string
+
+const (
+	// Aborted containers failed to be set up or handed over to stage1, or
+	// stage1 failed to start them
+	Aborted State = "aborted"
+	// Exited containers ran, and are done
+	Exited State = "exited"
+)
+
+// MarkRun records that the container rooted at root was handed over to
+// stage1
+func MarkRun(root string) error {
+	return writeInt(path.RunPath(root), os.Getpid())
+}
+
+// ReadState returns the state of the container rooted at root, which must
+// not be running, along with when it entered that state. The apps of the
+// container are the ones with the given image IDs.
+func ReadState(root string, imageIDs []types.Hash) (State, time.Time, error) {
+	pfi, err := os.Stat(path.PidPath(root))
+	switch {
+	case err == nil:
+		// the apps' exit statuses are written as they exit
+		t := pfi.ModTime()
+		for _, id := range imageIDs {
+			if fi, err := os.Stat(path.AppStatusPath(root, id)); err == nil && fi.ModTime().After(t) {
+				t = fi.ModTime()
+			}
+		}
+		return Exited, t, nil
+	case !os.IsNotExist(err):
+		return "", time.Time{}, err
+	}
+
+	if fi, err := os.Stat(path.RunPath(root)); err == nil {
+		return Aborted, fi.ModTime(), nil
+	} else if !os.IsNotExist(err) {
+		return "", time.Time{}, err
+	}
+
+	// stage0 died before handing the container over, which it sets up
+	// from creating root to writing the manifest
+	if fi, err := os.Stat(path.ContainerManifestPath(root)); err == nil {
+		return Aborted, fi.ModTime(), nil
+	} else if !os.IsNotExist(err) {
+		return "", time.Time{}, err
+	}
+
+	fi, err := os.Stat(root)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return Aborted, fi.ModTime(), nil
+}
+
+// ReadPid returns the pid of the init of the container rooted at root, as
+// recorded by the stage1 shim. The error satisfies os.IsNotExist if the
+// container was never started.
+func ReadPid(root string) (int, error) {
+	pid, err := readInt(path.PidPath(root))
+	if err == nil && pid <= 0 {
+		err = fmt.Errorf("invalid pid %d", pid)
+	}
+	return pid, err
+}
+
+// ReadExitStatus returns the exit status of the app with the given image ID
+// of the container rooted at root. The error satisfies os.IsNotExist if the
+// app has not exited, or never started.
+func ReadExitStatus(root string, imageID types.Hash) (int, error) {
+	return readInt(path.AppStatusPath(root, imageID))
+}
+
+func readInt(p string) (int, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("malformed %q: %v", p, err)
+	}
+	return i, nil
+}
+
+func writeInt(p string, i int) error {
+	tmp := filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(i)+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package status
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/path"
+)
+
+// writeShimPid records pid as the pid of the init of the container rooted
+// at dir, the way the fakesdboot.so shim of stage1 does: a decimal number
+// and a newline, in a file created with mode 0640 and renamed in place
+func writeShimPid(t *testing.T, dir string, pid int) {
+	tmp := filepath.Join(dir, "pid.tmp")
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d\n", pid)), 0640); err != nil {
+		t.Fatalf("error writing pid file: %v", err)
+	}
+	if err := os.Rename(tmp, path.PidPath(dir)); err != nil {
+		t.Fatalf("error writing pid file: %v", err)
+	}
+}
+
+// writeReaperStatus records status as the exit status of the app with the
+// given image ID of the container rooted at dir, the way the reaper of stage1
+// does: a decimal number and a newline, in a dot file renamed in place
+func writeReaperStatus(t *testing.T, dir string, imageID types.Hash, status int) {
+	p := path.AppStatusPath(dir, imageID)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatalf("error creating status dir: %v", err)
+	}
+	tmp := filepath.Join(filepath.Dir(p), "."+filepath.Base(p))
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d\n", status)), 0644); err != nil {
+		t.Fatalf("error writing status file: %v", err)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		t.Fatalf("error writing status file: %v", err)
+	}
+}
+
+func TestPid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := ReadPid(dir); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error reading missing pid, got %v", err)
+	}
+
+	writeShimPid(t, dir, 42)
+	if pid, err := ReadPid(dir); err != nil || pid != 42 {
+		t.Errorf("got pid %d (%v), wanted 42", pid, err)
+	}
+
+	for _, s := range []string{"", "x\n", "-1\n", "0"} {
+		if err := ioutil.WriteFile(path.PidPath(dir), []byte(s), 0640); err != nil {
+			t.Fatalf("error writing pid file: %v", err)
+		}
+		if _, err := ReadPid(dir); err == nil || os.IsNotExist(err) {
+			t.Errorf("%q: expected error reading malformed pid, got %v", s, err)
+		}
+	}
+
+	ls, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading dir: %v", err)
+	}
+	if len(ls) != 1 {
+		t.Errorf("unexpected files left behind: %d", len(ls))
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	h1 := types.NewHashSHA512([]byte("app1"))
+	h2 := types.NewHashSHA512([]byte("app2"))
+
+	if _, err := ReadExitStatus(dir, *h1); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error reading missing status, got %v", err)
+	}
+
+	// where the stage1 reaper writes it
+	if p := path.AppStatusPath(dir, *h2); filepath.Dir(p) != filepath.Join(dir, "stage1/rkt/status") {
+		t.Errorf("unexpected status path %q", p)
+	}
+	writeReaperStatus(t, dir, *h1, 0)
+	writeReaperStatus(t, dir, *h2, 3)
+
+	if s, err := ReadExitStatus(dir, *h1); err != nil || s != 0 {
+		t.Errorf("got status %d (%v), wanted 0", s, err)
+	}
+	if s, err := ReadExitStatus(dir, *h2); err != nil || s != 3 {
+		t.Errorf("got status %d (%v), wanted 3", s, err)
+	}
+}
+
+func TestReadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ids := []types.Hash{*types.NewHashSHA512([]byte("app1")), *types.NewHashSHA512([]byte("app2"))}
+	check := func(what string, want State, since time.Time) {
+		st, t0, err := ReadState(dir, ids)
+		if err != nil {
+			t.Fatalf("%s: error reading state: %v", what, err)
+		}
+		if st != want || !t0.Equal(since) {
+			t.Errorf("%s: got %s since %v, wanted %s since %v", what, st, t0, want, since)
+		}
+	}
+	touch := func(p string, t0 time.Time) {
+		if err := os.Chtimes(p, t0, t0); err != nil {
+			t.Fatalf("error setting times of %q: %v", p, err)
+		}
+	}
+	now := time.Now().Truncate(time.Second)
+
+	// stage0 died before writing the manifest
+	touch(dir, now.Add(-5*time.Hour))
+	check("setup", Aborted, now.Add(-5*time.Hour))
+
+	if err := ioutil.WriteFile(path.ContainerManifestPath(dir), []byte("{}"), 0644); err != nil {
+		t.Fatalf("error writing manifest: %v", err)
+	}
+	touch(path.ContainerManifestPath(dir), now.Add(-4*time.Hour))
+	check("manifest", Aborted, now.Add(-4*time.Hour))
+
+	if err := MarkRun(dir); err != nil {
+		t.Fatalf("error marking run: %v", err)
+	}
+	touch(path.RunPath(dir), now.Add(-3*time.Hour))
+	check("run", Aborted, now.Add(-3*time.Hour))
+
+	writeShimPid(t, dir, 1234)
+	touch(path.PidPath(dir), now.Add(-2*time.Hour))
+	check("pid", Exited, now.Add(-2*time.Hour))
+
+	writeReaperStatus(t, dir, ids[1], 0)
+	touch(path.AppStatusPath(dir, ids[1]), now.Add(-time.Hour))
+	check("exit status", Exited, now.Add(-time.Hour))
+}
@@ -0,0 +1,118 @@
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// BuildOptions tweak how BuildTar writes a tarball
+type BuildOptions struct {
+	// NormalizeTimes replaces the times of all entries with ModTime, or
+	// the epoch if it is zero, for reproducible tarballs
+	NormalizeTimes bool
+	ModTime        time.Time
+	// Exclude holds the paths left out of the tarball along with their
+	// children, relative to the tree and starting with /
+	Exclude map[string]struct{}
+}
+
+// BuildTar writes the tree rooted at dir to tw, in lexical order. Hard links
+// within the tree are kept, owners are numeric, and extended attributes are
+// written as PAX records. Sockets are skipped. The tar.Writer is not closed.
+func BuildTar(tw *tar.Writer, dir string, opts BuildOptions) error {
+	mtime := opts.ModTime
+	if mtime.IsZero() {
+		mtime = time.Unix(0, 0)
+	}
+	// by device and inode, the first name of the files with several links
+	links := make(map[[2]uint64]string)
+
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if _, ok := opts.Exclude[filepath.Join("/", rel)]; ok {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return fmt.Errorf("error creating header for %q: %v", p, err)
+		}
+		hdr.Name = rel
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname = ""
+		hdr.Gname = ""
+		if opts.NormalizeTimes {
+			hdr.ModTime = mtime
+		}
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && fi.Mode().IsRegular() && st.Nlink > 1 {
+			key := [2]uint64{uint64(st.Dev), st.Ino}
+			if first, ok := links[key]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				links[key] = rel
+			}
+		}
+
+		if hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeLink {
+			xattrs, err := getXattrs(p)
+			if err != nil {
+				return err
+			}
+			for k, v := range xattrs {
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = make(map[string]string)
+				}
+				hdr.PAXRecords[paxXattrPrefix+k] = v
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing header for %q: %v", p, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("error writing %q: %v", p, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,222 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// makeTestTree creates a tree with all kinds of files in a new directory
+func makeTestTree(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rocket-temp-dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mkdir := func(name string, mode os.FileMode) {
+		if err := os.Mkdir(filepath.Join(dir, name), mode); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	write := func(name, contents string, mode os.FileMode) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), mode); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	mkdir("bin", 0755)
+	write("bin/sh", "#!/bin/sh\n", 04755)
+	if err := os.Link(filepath.Join(dir, "bin/sh"), filepath.Join(dir, "bin/bash")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Symlink("sh", filepath.Join(dir, "bin/dash")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mkdir("etc", 0750)
+	write("etc/hosts", "127.0.0.1 localhost\n", 0644)
+	if err := syscall.Setxattr(filepath.Join(dir, "etc/hosts"), "user.rkt", []byte("yes"), 0); err != nil && err != syscall.ENOTSUP {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mkdir("dev", 0755)
+	if err := syscall.Mkfifo(filepath.Join(dir, "dev/initctl"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if os.Geteuid() == 0 {
+		if err := syscall.Mknod(filepath.Join(dir, "dev/null"), 0666|syscall.S_IFCHR, makedev(1, 3)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.Lchown(filepath.Join(dir, "etc/hosts"), 1000, 1001); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	mkdir("tmp", 01777)
+	write("tmp/junk", "junk", 0644)
+
+	mtime := time.Unix(1400000000, 0)
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return lutimesNano(p, []syscall.Timespec{syscall.NsecToTimespec(mtime.UnixNano()), syscall.NsecToTimespec(mtime.UnixNano())})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return dir
+}
+
+// treeFile describes a file of a tree for comparisons
+type treeFile struct {
+	mode     os.FileMode
+	uid, gid uint32
+	rdev     uint64
+	mtime    time.Time
+	contents string
+	xattrs   map[string]string
+	links    uint64
+}
+
+// readTree describes the files in the tree rooted at dir
+func readTree(t *testing.T, dir string) map[string]treeFile {
+	tree := make(map[string]treeFile)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		f := treeFile{mode: fi.Mode(), uid: st.Uid, gid: st.Gid, rdev: uint64(st.Rdev), mtime: fi.ModTime(), links: uint64(st.Nlink)}
+		switch {
+		case fi.Mode().IsRegular():
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			f.contents = string(b)
+		case fi.Mode()&os.ModeSymlink != 0:
+			if f.contents, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		if fi.IsDir() {
+			f.links = 0
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			if f.xattrs, err = getXattrs(p); err != nil {
+				return err
+			}
+		}
+		tree[rel] = f
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return tree
+}
+
+func TestBuildTarRoundTrip(t *testing.T) {
+	src := makeTestTree(t)
+	defer os.RemoveAll(src)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := BuildTar(tw, src, BuildOptions{Exclude: map[string]struct{}{"/tmp": {}}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dst, err := ioutil.TempDir("", "rocket-temp-dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dst)
+	if err := ExtractTar(tar.NewReader(&buf), dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := readTree(t, src)
+	delete(want, "tmp")
+	delete(want, "tmp/junk")
+	got := readTree(t, dst)
+	if os.Geteuid() != 0 {
+		// ownership is only restored as root
+		for name, f := range want {
+			f.uid, f.gid = got[name].uid, got[name].gid
+			want[name] = f
+		}
+	}
+	for name, f := range want {
+		if !reflect.DeepEqual(got[name], f) {
+			t.Errorf("%s: got %+v, wanted %+v", name, got[name], f)
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("%s: unexpected file", name)
+		}
+	}
+}
+
+func TestBuildTarDeterministic(t *testing.T) {
+	build := func() []byte {
+		dir := makeTestTree(t)
+		defer os.RemoveAll(dir)
+		// as if created at another time
+		now := syscall.NsecToTimespec(time.Now().UnixNano())
+		if err := lutimesNano(filepath.Join(dir, "etc/hosts"), []syscall.Timespec{now, now}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := BuildTar(tw, dir, BuildOptions{NormalizeTimes: true}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	b := build()
+	if !bytes.Equal(b, build()) {
+		t.Errorf("tarballs of identical trees differ")
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if !hdr.ModTime.Equal(time.Unix(0, 0)) {
+			t.Errorf("%s: unexpected mtime: %v", hdr.Name, hdr.ModTime)
+		}
+		if hdr.Name == "bin/sh" && (hdr.Typeflag != tar.TypeLink || hdr.Linkname != "bin/bash") {
+			t.Errorf("%s: expected hard link to bin/bash, got %c %q", hdr.Name, hdr.Typeflag, hdr.Linkname)
+		}
+		names = append(names, hdr.Name)
+	}
+	wnames := []string{"bin/", "bin/bash", "bin/dash", "bin/sh", "dev/", "dev/initctl", "etc/", "etc/hosts", "tmp/", "tmp/junk"}
+	if os.Geteuid() == 0 {
+		wnames = []string{"bin/", "bin/bash", "bin/dash", "bin/sh", "dev/", "dev/initctl", "dev/null", "etc/", "etc/hosts", "tmp/", "tmp/junk"}
+	}
+	if !reflect.DeepEqual(names, wnames) {
+		t.Errorf("got entries %v, wanted %v", names, wnames)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,87 +13,385 @@ import (
 
 const DEFAULT_DIR_MODE os.FileMode = 0755
 
-type insecureLinkError error
+// paxXattrPrefix prefixes the PAX records carrying extended attributes
+const paxXattrPrefix = "SCHILY.xattr."
+
+// Whiteout entries delete what lower layers extracted at the path named
+// after the prefix, and opaque whiteouts everything in their directory.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// maxSymlinks bounds the symlinks followed resolving a path, like the kernel
+// does
+const maxSymlinks = 40
+
+// insecurePathError reports an entry which would be extracted outside of the
+// target directory
+type insecurePathError struct {
+	msg string
+}
+
+func (e *insecurePathError) Error() string {
+	return e.msg
+}
+
+// Options tweak how ExtractTarWithOptions extracts a tarball
+type Options struct {
+	// UidOffset and GidOffset are added to the owners of the entries,
+	// to extract a tarball for use in a user namespace
+	UidOffset int
+	GidOffset int
+	// PathWhitelist, if not nil, restricts the extraction to the entries
+	// it holds, by path relative to the tarball and starting with /.
+	// Whiteouts only delete the paths it holds.
+	PathWhitelist map[string]struct{}
+	// Whiteouts tells that the tarball is a layer over what is already in
+	// the directory, whose whiteout entries delete files rather than
+	// being extracted
+	Whiteouts bool
+	// Warn, if not nil, is told about what could not be restored without
+	// failing the extraction, like extended attributes the filesystem does
+	// not support
+	Warn func(error)
+}
 
 // ExtractTar extracts a tarball (from a tar.Reader) into the given directory
 func ExtractTar(tr *tar.Reader, dir string) error {
+	return ExtractTarWithOptions(tr, dir, Options{})
+}
+
+// ExtractTarWithOptions extracts a tarball (from a tar.Reader) into the
+// given directory, restoring the modes, timestamps and extended attributes
+// of the entries. Ownership is only restored when running as root, like
+// tar(1) does.
+// The entries replace the files in their way, and the directories are merged
+// with existing ones, so that the tarball can be a layer over what is already
+// in dir, whose whiteouts delete files if opts.Whiteouts is set.
+func ExtractTarWithOptions(tr *tar.Reader, dir string, opts Options) error {
 	um := syscall.Umask(0)
 	defer syscall.Umask(um)
+
+	// directories get their times once their children are extracted
+	type dirTimes struct {
+		path string
+		hdr  *tar.Header
+	}
+	var dirs []dirTimes
+	// kept by opaque whiteouts
+	extracted := make(map[string]bool)
 	for {
 		hdr, err := tr.Next()
 		switch err {
 		case io.EOF:
+			for _, d := range dirs {
+				if err := setTimes(d.path, d.hdr); err != nil {
+					return err
+				}
+			}
 			return nil
 		case nil:
-			p := filepath.Join(dir, hdr.Name)
-			fi := hdr.FileInfo()
-			typ := hdr.Typeflag
+			if opts.Whiteouts && strings.HasPrefix(filepath.Base(hdr.Name), whiteoutPrefix) {
+				if err := applyWhiteout(dir, hdr.Name, extracted, opts.PathWhitelist); err != nil {
+					return err
+				}
+				continue
+			}
+			if !whitelisted(opts.PathWhitelist, hdr.Name) {
+				continue
+			}
+			p, err := resolveInRoot(dir, hdr.Name)
+			if err != nil {
+				return err
+			}
+			if err := extractFile(tr, hdr, p, dir, opts); err != nil {
+				return err
+			}
+			extracted[p] = true
+			if hdr.Typeflag == tar.TypeDir {
+				dirs = append(dirs, dirTimes{p, hdr})
+			}
+		default:
+			return fmt.Errorf("error extracting tarball: %v", err)
+		}
+	}
+}
+
+// extractFile extracts the entry hdr, whose contents are read from tr, at p
+// in dir
+func extractFile(tr *tar.Reader, hdr *tar.Header, p, dir string, opts Options) error {
+	fi := hdr.FileInfo()
+	typ := hdr.Typeflag
+	perm := uint32(hdr.Mode & 07777)
+
+	// Create parent dir if it doesn't exists
+	if err := os.MkdirAll(filepath.Dir(p), DEFAULT_DIR_MODE); err != nil {
+		return err
+	}
+	if p != filepath.Clean(dir) {
+		if err := clearPath(p, typ == tar.TypeDir); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case typ == tar.TypeReg || typ == tar.TypeRegA:
+		// never write through a symlink
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_TRUNC|syscall.O_NOFOLLOW, fi.Mode())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		f.Close()
+	case typ == tar.TypeDir:
+		if err := os.Mkdir(p, fi.Mode()); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case typ == tar.TypeLink:
+		dest, err := resolveInRoot(dir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		// the target holds the metadata
+		return os.Link(dest, p)
+	case typ == tar.TypeSymlink:
+		if err := checkSymlink(dir, p, hdr); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, p); err != nil {
+			return err
+		}
+	case typ == tar.TypeChar:
+		dev := makedev(int(hdr.Devmajor), int(hdr.Devminor))
+		if err := syscall.Mknod(p, perm|syscall.S_IFCHR, dev); err != nil {
+			return err
+		}
+	case typ == tar.TypeBlock:
+		dev := makedev(int(hdr.Devmajor), int(hdr.Devminor))
+		if err := syscall.Mknod(p, perm|syscall.S_IFBLK, dev); err != nil {
+			return err
+		}
+	case typ == tar.TypeFifo:
+		if err := syscall.Mkfifo(p, perm); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported type: %v", typ)
+	}
+
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(p, hdr.Uid+opts.UidOffset, hdr.Gid+opts.GidOffset); err != nil {
+			return err
+		}
+	}
+	if typ == tar.TypeSymlink {
+		return setTimes(p, hdr)
+	}
+	// after chown, which clears file capabilities
+	for k, v := range hdr.PAXRecords {
+		if !strings.HasPrefix(k, paxXattrPrefix) {
+			continue
+		}
+		attr := strings.TrimPrefix(k, paxXattrPrefix)
+		switch err := setXattr(p, attr, v); err {
+		case nil:
+		case syscall.ENOTSUP, syscall.EPERM:
+			// like tar(1), carry on without attributes the filesystem
+			// or our privileges do not allow
+			if opts.Warn != nil {
+				opts.Warn(fmt.Errorf("unable to set xattr %q on %q: %v", attr, p, err))
+			}
+		default:
+			return fmt.Errorf("error setting xattr %q on %q: %v", attr, p, err)
+		}
+	}
+	// chown clears the setuid and setgid bits
+	if err := os.Chmod(p, fi.Mode()); err != nil {
+		return err
+	}
+	if typ == tar.TypeDir {
+		return nil
+	}
+	return setTimes(p, hdr)
+}
 
-			// Create parent dir if it doesn't exists
-			if err := os.MkdirAll(filepath.Dir(p), DEFAULT_DIR_MODE); err != nil {
+// clearPath makes way at p for an entry by removing what is there, unless
+// both are directories, which get merged
+func clearPath(p string, dir bool) error {
+	fi, err := os.Lstat(p)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case fi.IsDir() && dir:
+		return nil
+	}
+	return os.RemoveAll(p)
+}
+
+// whitelisted tells whether the entry name is extracted given the path
+// whitelist pwl, see Options
+func whitelisted(pwl map[string]struct{}, name string) bool {
+	if pwl == nil {
+		return true
+	}
+	_, ok := pwl[filepath.Join("/", name)]
+	return ok
+}
+
+// applyWhiteout deletes from root what the whiteout entry name hides, if
+// whitelisted in pwl, except for the paths extracted from the same tarball
+func applyWhiteout(root, name string, extracted map[string]bool, pwl map[string]struct{}) error {
+	dir, base := filepath.Split(name)
+	if base == whiteoutOpaque {
+		// resolve the directory itself too
+		p, err := resolveInRoot(root, name)
+		if err != nil {
+			return err
+		}
+		p = filepath.Dir(p)
+		ls, err := ioutil.ReadDir(p)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, fi := range ls {
+			c := filepath.Join(p, fi.Name())
+			if extracted[c] || !whitelisted(pwl, dir+fi.Name()) {
+				continue
+			}
+			if err := os.RemoveAll(c); err != nil {
 				return err
 			}
+		}
+		return nil
+	}
 
-			switch {
-			case typ == tar.TypeReg || typ == tar.TypeRegA:
-				f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, fi.Mode())
-				if err != nil {
-					f.Close()
-					return err
-				}
-				_, err = io.Copy(f, tr)
-				if err != nil {
-					f.Close()
-					return err
-				}
-				f.Close()
-			case typ == tar.TypeDir:
-				if err := os.MkdirAll(p, fi.Mode()); err != nil {
-					return err
-				}
-				dir, err := os.Open(p)
-				if err != nil {
-					return err
-				}
-				if err := dir.Chmod(fi.Mode()); err != nil {
-					return err
-				}
-			case typ == tar.TypeLink:
-				dest := filepath.Join(dir, hdr.Linkname)
-				if !strings.HasPrefix(dest, dir) {
-					return insecureLinkError(fmt.Errorf("insecure link %q -> %q", p, hdr.Linkname))
-				}
-				if err := os.Link(dest, p); err != nil {
-					return err
-				}
-			case typ == tar.TypeSymlink:
-				dest := filepath.Join(filepath.Dir(p), hdr.Linkname)
-				if !strings.HasPrefix(dest, dir) {
-					return insecureLinkError(fmt.Errorf("insecure symlink %q -> %q", p, hdr.Linkname))
-				}
-				if err := os.Symlink(hdr.Linkname, p); err != nil {
-					return err
-				}
-			case typ == tar.TypeChar:
-				dev := makedev(int(hdr.Devmajor), int(hdr.Devminor))
-				mode := uint32(fi.Mode()) | syscall.S_IFCHR
-				if err := syscall.Mknod(p, mode, dev); err != nil {
-					return err
-				}
-			case typ == tar.TypeBlock:
-				dev := makedev(int(hdr.Devmajor), int(hdr.Devminor))
-				mode := uint32(fi.Mode()) | syscall.S_IFBLK
-				if err := syscall.Mknod(p, mode, dev); err != nil {
-					return err
-				}
-			// TODO(jonboulle): implement other modes
-			default:
-				return fmt.Errorf("unsupported type: %v", typ)
+	hidden := strings.TrimPrefix(base, whiteoutPrefix)
+	if hidden == "" || hidden == "." || hidden == ".." {
+		return &insecurePathError{fmt.Sprintf("invalid whiteout %q", name)}
+	}
+	if !whitelisted(pwl, dir+hidden) {
+		return nil
+	}
+	p, err := resolveInRoot(root, dir+hidden)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+// checkSymlink fails if the symlink entry hdr, extracted at p in root, would
+// point out of root. Absolute symlinks point within root, as the entries are
+// resolved as if root were /. Relative ones are checked from the directory p
+// is actually in, which symlinks may have moved away from the entry's name.
+// They may only go up before going down, as a later entry could turn what
+// they would go up from into a symlink.
+func checkSymlink(root, p string, hdr *tar.Header) error {
+	if filepath.IsAbs(hdr.Linkname) {
+		return nil
+	}
+	insecure := &insecurePathError{fmt.Sprintf("insecure symlink %q -> %q", hdr.Name, hdr.Linkname)}
+	down := false
+	for _, c := range strings.Split(hdr.Linkname, "/") {
+		switch c {
+		case "", ".":
+		case "..":
+			if down {
+				return insecure
 			}
 		default:
-			return fmt.Errorf("error extracting tarball: %v", err)
+			down = true
 		}
 	}
+	parent, err := filepath.Rel(filepath.Clean(root), filepath.Dir(p))
+	if err != nil {
+		return err
+	}
+	if escapes(filepath.Join(parent, hdr.Linkname)) {
+		return insecure
+	}
+	return nil
+}
+
+// escapes tells whether the relative path name points out of its root
+func escapes(name string) bool {
+	name = filepath.Clean(name)
+	return name == ".." || strings.HasPrefix(name, "../")
+}
+
+// resolveInRoot returns where the entry name of a tarball is extracted in
+// root, following the symlinks of its parent directories as if root were /.
+// It fails if name is absolute, or would be extracted out of root.
+func resolveInRoot(root, name string) (string, error) {
+	if filepath.IsAbs(name) || escapes(name) {
+		return "", &insecurePathError{fmt.Sprintf("insecure path %q", name)}
+	}
+	dir, base := filepath.Split(filepath.Clean(name))
+
+	var resolved []string
+	rest := strings.Split(dir, "/")
+	links := 0
+	for len(rest) > 0 {
+		c := rest[0]
+		rest = rest[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", &insecurePathError{fmt.Sprintf("insecure path %q: escapes through symlinks", name)}
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		p := filepath.Join(root, filepath.Join(resolved...), c)
+		fi, err := os.Lstat(p)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// creating the missing directories reports other errors
+			resolved = append(resolved, c)
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("error resolving %q: %v", name, syscall.ELOOP)
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			// like the kernel, stop at / going up from it
+			target = filepath.Clean(target)
+			resolved = nil
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return filepath.Join(root, filepath.Join(resolved...), base), nil
+}
+
+// setTimes sets the access and modification times of p, not following
+// symlinks, as recorded in hdr
+func setTimes(p string, hdr *tar.Header) error {
+	if hdr.ModTime.IsZero() {
+		return nil
+	}
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	ts := []syscall.Timespec{
+		syscall.NsecToTimespec(atime.UnixNano()),
+		syscall.NsecToTimespec(hdr.ModTime.UnixNano()),
+	}
+	return lutimesNano(p, ts)
 }
 
 // makedev mimics glib's gnu_dev_makedev
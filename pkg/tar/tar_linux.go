@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+package tar
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// AT_FDCWD and AT_SYMLINK_NOFOLLOW, which syscall does not export
+const (
+	atFdcwd           = -0x64
+	atSymlinkNofollow = 0x100
+)
+
+// lutimesNano is syscall.UtimesNano, without following symlinks
+func lutimesNano(p string, ts []syscall.Timespec) error {
+	return utimensat(atFdcwd, p, ts, atSymlinkNofollow)
+}
+
+// utimensat wraps utimensat(2), which syscall only uses to follow symlinks
+func utimensat(dirfd int, p string, ts []syscall.Timespec, flags int) error {
+	pp, err := syscall.BytePtrFromString(p)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(dirfd), uintptr(unsafe.Pointer(pp)), uintptr(unsafe.Pointer(&ts[0])), uintptr(flags), 0, 0)
+	if errno != 0 {
+		return &os.PathError{Op: "utimensat", Path: p, Err: errno}
+	}
+	return nil
+}
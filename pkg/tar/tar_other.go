@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package tar
+
+import (
+	"os"
+	"syscall"
+)
+
+// lutimesNano is syscall.UtimesNano, without following symlinks: the times
+// of symlinks are left alone
+func lutimesNano(p string, ts []syscall.Timespec) error {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return syscall.UtimesNano(p, ts)
+}
@@ -6,7 +6,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 type testTarEntry struct {
@@ -14,12 +17,17 @@ type testTarEntry struct {
 	contents string
 }
 
-func newTestTar(entries []*testTarEntry) (string, error) {
+func newTestTar(entries []*testTarEntry) (path string, err error) {
 	t, err := ioutil.TempFile("", "test-tar")
 	if err != nil {
 		return "", err
 	}
 	defer t.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(t.Name())
+		}
+	}()
 	tw := tar.NewWriter(t)
 	for _, entry := range entries {
 		if err := tw.WriteHeader(entry.header); err != nil {
@@ -88,8 +96,8 @@ func TestExtractTarInsecureSymlink(t *testing.T) {
 			t.Errorf("unexpected error: %v", err)
 		}
 		err = ExtractTar(tr, tmpdir)
-		if _, ok := err.(insecureLinkError); !ok {
-			t.Errorf("expected insecureSymlinkError error")
+		if _, ok := err.(*insecurePathError); !ok {
+			t.Errorf("expected insecurePathError error, got %v", err)
 		}
 	}
 }
@@ -202,3 +210,535 @@ func TestExtractTarFolders(t *testing.T) {
 	}
 
 }
+
+func TestExtractTarMetadata(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("ownership is only restored as root")
+	}
+	mtime := time.Unix(1400000000, 0)
+	dtime := time.Unix(1300000000, 0)
+	entries := []*testTarEntry{
+		{
+			header: &tar.Header{
+				Name:     "dir/",
+				Typeflag: tar.TypeDir,
+				Mode:     int64(0750),
+				Uid:      100,
+				Gid:      200,
+				ModTime:  dtime,
+			},
+		},
+		{
+			contents: "foo",
+			header: &tar.Header{
+				Name:    "dir/foo",
+				Mode:    int64(04755),
+				Size:    3,
+				Uid:     1000,
+				Gid:     1001,
+				ModTime: mtime,
+				PAXRecords: map[string]string{
+					"SCHILY.xattr.user.rkt": "yes",
+					// unknown namespace, only warned about
+					"SCHILY.xattr.bogus.rkt": "yes",
+				},
+			},
+		},
+		{
+			header: &tar.Header{
+				Name:     "dir/link",
+				Typeflag: tar.TypeSymlink,
+				Linkname: "foo",
+				Uid:      1002,
+				Gid:      1003,
+				ModTime:  dtime,
+			},
+		},
+		{
+			header: &tar.Header{
+				Name:     "dir/fifo",
+				Typeflag: tar.TypeFifo,
+				Mode:     int64(0640),
+				ModTime:  mtime,
+			},
+		},
+	}
+
+	testTarPath, err := newTestTar(entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(testTarPath)
+	containerTar, err := os.Open(testTarPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer containerTar.Close()
+	tmpdir, err := ioutil.TempDir("", "rocket-temp-dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	var warnings []string
+	opts := Options{
+		UidOffset: 100000,
+		GidOffset: 200000,
+		Warn:      func(err error) { warnings = append(warnings, err.Error()) },
+	}
+	err = ExtractTarWithOptions(tar.NewReader(containerTar), tmpdir, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	warned := false
+	for _, w := range warnings {
+		warned = warned || strings.Contains(w, `"bogus.rkt"`)
+	}
+	if !warned {
+		t.Errorf("no warning about the bogus xattr: %q", warnings)
+	}
+
+	tests := []struct {
+		path  string
+		mode  os.FileMode
+		uid   uint32
+		gid   uint32
+		mtime time.Time
+	}{
+		{"dir", os.ModeDir | 0750, 100100, 200200, dtime},
+		{"dir/foo", os.ModeSetuid | 0755, 101000, 201001, mtime},
+		{"dir/link", os.ModeSymlink | 0777, 101002, 201003, dtime},
+		{"dir/fifo", os.ModeNamedPipe | 0640, 100000, 200000, mtime},
+	}
+	for _, tt := range tests {
+		fi, err := os.Lstat(filepath.Join(tmpdir, tt.path))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if fi.Mode() != tt.mode {
+			t.Errorf("%s: unexpected mode: %v, wanted %v", tt.path, fi.Mode(), tt.mode)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != tt.uid || st.Gid != tt.gid {
+			t.Errorf("%s: unexpected owner: %d:%d, wanted %d:%d", tt.path, st.Uid, st.Gid, tt.uid, tt.gid)
+		}
+		if !fi.ModTime().Equal(tt.mtime) {
+			t.Errorf("%s: unexpected mtime: %v, wanted %v", tt.path, fi.ModTime(), tt.mtime)
+		}
+	}
+
+	buf := make([]byte, 16)
+	n, err := syscall.Getxattr(filepath.Join(tmpdir, "dir/foo"), "user.rkt", buf)
+	if err == syscall.ENOTSUP {
+		t.Skipf("xattrs unsupported: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf[:n]) != "yes" {
+		t.Errorf("unexpected xattr: %q", buf[:n])
+	}
+}
+
+// extractInSandbox extracts entries in the root dir of a new sandbox, next
+// to an outside dir and a root-evil dir each holding a secret file. The
+// string OUTSIDE in the names and link names of the entries is replaced by
+// the path of the outside dir.
+func extractInSandbox(t testing.TB, entries []*testTarEntry) (string, error) {
+	sandbox, err := ioutil.TempDir("", "rocket-temp-dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, d := range []string{"root", "outside", "root-evil"} {
+		if err := os.Mkdir(filepath.Join(sandbox, d), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d == "root" {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(sandbox, d, "secret"), []byte("secret"), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	outside := filepath.Join(sandbox, "outside")
+	for _, e := range entries {
+		e.header.Name = strings.Replace(e.header.Name, "OUTSIDE", outside, -1)
+		e.header.Linkname = strings.Replace(e.header.Linkname, "OUTSIDE", outside, -1)
+		if e.header.Typeflag == tar.TypeReg {
+			e.header.Size = int64(len(e.contents))
+		}
+	}
+	testTarPath, err := newTestTar(entries)
+	if err != nil {
+		os.RemoveAll(sandbox)
+		t.Skipf("unable to create tarball: %v", err)
+	}
+	defer os.Remove(testTarPath)
+	return sandbox, extractTestTar(t, testTarPath, filepath.Join(sandbox, "root"), Options{Whiteouts: true})
+}
+
+// extractTestTar extracts the tarball at testTarPath into dir
+func extractTestTar(t testing.TB, testTarPath, dir string, opts Options) error {
+	containerTar, err := os.Open(testTarPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer containerTar.Close()
+	return ExtractTarWithOptions(tar.NewReader(containerTar), dir, opts)
+}
+
+// checkSandbox fails if anything in sandbox changed out of its root dir
+func checkSandbox(t testing.TB, sandbox string) {
+	err := filepath.Walk(sandbox, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(sandbox, p)
+		if err != nil {
+			return err
+		}
+		switch rel {
+		case ".", "root", "outside", "root-evil":
+			if !fi.IsDir() || fi.Mode().Perm() != 0755 && rel != "." {
+				t.Errorf("%s changed: %v", rel, fi.Mode())
+			}
+		case "outside/secret", "root-evil/secret":
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() || fi.Mode().Perm() != 0600 || string(b) != "secret" {
+				t.Errorf("%s changed: %v %q", rel, fi.Mode(), b)
+			}
+		default:
+			if !strings.HasPrefix(rel, "root/") {
+				t.Errorf("unexpected file out of root: %s", rel)
+			} else if fi.Mode()&os.ModeSymlink != 0 && linkEscapes(filepath.Join(sandbox, "root"), p) {
+				t.Errorf("symlink leading out of root: %s", rel)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// linkEscapes tells whether following the symlink at p in root leads out of
+// root, the way the kernel resolves it. Absolute symlinks are not followed,
+// as they are meant to be resolved as if root were /.
+func linkEscapes(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return true
+	}
+	var resolved []string
+	rest := strings.Split(rel, "/")
+	for links := 0; len(rest) > 0; {
+		c := rest[0]
+		rest = rest[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return true
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		q := filepath.Join(root, filepath.Join(resolved...), c)
+		fi, err := os.Lstat(q)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, c)
+			continue
+		}
+		target, err := os.Readlink(q)
+		if err != nil || filepath.IsAbs(target) {
+			return false
+		}
+		if links++; links > maxSymlinks {
+			return false
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return false
+}
+
+func TestExtractTarAdversarial(t *testing.T) {
+	file := func(name string) *testTarEntry {
+		return &testTarEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0666}, contents: "evil"}
+	}
+	dir := func(name string) *testTarEntry {
+		return &testTarEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0777}}
+	}
+	symlink := func(name, target string) *testTarEntry {
+		return &testTarEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}}
+	}
+	link := func(name, target string) *testTarEntry {
+		return &testTarEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: target}}
+	}
+
+	const (
+		ok = iota
+		insecure
+		failure
+	)
+	tests := []struct {
+		name    string
+		entries []*testTarEntry
+		result  int
+		created string // in root, on success
+	}{
+		{"dotdot", []*testTarEntry{file("../evil")}, insecure, ""},
+		{"absolute", []*testTarEntry{file("OUTSIDE/evil")}, insecure, ""},
+		{"inner dotdot", []*testTarEntry{file("a/../../evil")}, insecure, ""},
+		{"dotdot dir", []*testTarEntry{dir("../outside/")}, insecure, ""},
+		{"symlink to parent", []*testTarEntry{symlink("l", "..")}, insecure, ""},
+		{"deep symlink to parent", []*testTarEntry{symlink("a/b/l", "../../../outside")}, insecure, ""},
+		{"hardlink to sibling", []*testTarEntry{link("l", "../root-evil/secret")}, insecure, ""},
+		{"absolute hardlink", []*testTarEntry{link("l", "OUTSIDE/secret")}, insecure, ""},
+		{"hardlink through symlink", []*testTarEntry{symlink("l", "OUTSIDE"), link("h", "l/secret")}, failure, ""},
+		{"symlinks to parent through symlink", []*testTarEntry{
+			dir("d/"),
+			symlink("d/up", ".."),
+			symlink("d/up2", "up/.."),
+			file("d/up2/evil"),
+		}, insecure, ""},
+		{"absolute symlink parent", []*testTarEntry{symlink("l", "/"), file("l/evil")}, ok, "evil"},
+		{"absolute symlink with dotdot parent", []*testTarEntry{symlink("l", "/../../outside"), file("l/evil")}, ok, "outside/evil"},
+		{"absolute symlink to outside parent", []*testTarEntry{symlink("l", "OUTSIDE"), file("l/evil")}, ok, "OUTSIDE/evil"},
+		{"file over symlink", []*testTarEntry{symlink("l", "OUTSIDE/secret"), file("l")}, ok, "l"},
+		{"dir over symlink", []*testTarEntry{symlink("l", "OUTSIDE"), dir("l/")}, ok, "l"},
+		{"whiteout through symlink", []*testTarEntry{symlink("l", "OUTSIDE"), file("l/.wh.secret")}, ok, "l"},
+		{"opaque whiteout through symlink", []*testTarEntry{symlink("l", "OUTSIDE"), file("l/.wh..wh..opq")}, ok, "l"},
+		{"whiteout of root", []*testTarEntry{file("a/.wh..")}, insecure, ""},
+		{"whiteout of parent", []*testTarEntry{file(".wh..")}, insecure, ""},
+		{"symlink out of an absolute symlink parent", []*testTarEntry{symlink("sub", "/"), symlink("sub/l", "../secret")}, insecure, ""},
+		{"symlink up through symlink", []*testTarEntry{symlink("s", "."), symlink("l", "s/..")}, insecure, ""},
+		{"symlink up through later symlink", []*testTarEntry{symlink("l", "s/.."), symlink("s", ".")}, insecure, ""},
+		{"symlink up from subdir", []*testTarEntry{symlink("a/b/l", "../../c")}, ok, "a/b/l"},
+		{"symlink loop", []*testTarEntry{symlink("a", "b"), symlink("b", "a"), file("a/evil")}, failure, ""},
+	}
+	for _, tt := range tests {
+		sandbox, err := extractInSandbox(t, tt.entries)
+		switch tt.result {
+		case ok:
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			created := strings.Replace(tt.created, "OUTSIDE", filepath.Join(sandbox, "outside"), -1)
+			if _, err := os.Lstat(filepath.Join(sandbox, "root", created)); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+		case insecure:
+			if _, ok := err.(*insecurePathError); !ok {
+				t.Errorf("%s: expected insecurePathError error, got %v", tt.name, err)
+			}
+		case failure:
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+		}
+		checkSandbox(t, sandbox)
+		os.RemoveAll(sandbox)
+	}
+}
+
+func FuzzExtractTar(f *testing.F) {
+	f.Add("l", "/", byte(tar.TypeSymlink), "l/d/", "", byte(tar.TypeDir), "l/d/evil")
+	f.Add("l", "..", byte(tar.TypeSymlink), "m", "l", byte(tar.TypeSymlink), "m/evil")
+	f.Add("a/l", "OUTSIDE", byte(tar.TypeSymlink), "h", "a/l/secret", byte(tar.TypeLink), "a/l")
+	f.Add("d/", "", byte(tar.TypeDir), "d/up", "../d/..", byte(tar.TypeSymlink), "d/up/../evil")
+	f.Add("l", "OUTSIDE/secret", byte(tar.TypeSymlink), "h", "l", byte(tar.TypeLink), "h")
+	f.Add("../root-evil/x", "", byte(tar.TypeReg), "./.././x", "", byte(tar.TypeDir), "/x")
+	f.Add("sub", "/", byte(tar.TypeSymlink), "sub/l", "../secret", byte(tar.TypeSymlink), "x")
+	f.Add("s", ".", byte(tar.TypeSymlink), "l", "s/..", byte(tar.TypeSymlink), "x")
+	f.Fuzz(func(t *testing.T, name1, link1 string, typ1 byte, name2, link2 string, typ2 byte, name3 string) {
+		entry := func(name, link string, typ byte) *testTarEntry {
+			types := []byte{tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink}
+			return &testTarEntry{
+				header:   &tar.Header{Name: name, Linkname: link, Typeflag: types[int(typ)%len(types)], Mode: 0777},
+				contents: "evil",
+			}
+		}
+		sandbox, _ := extractInSandbox(t, []*testTarEntry{
+			entry(name1, link1, typ1),
+			entry(name2, link2, typ2),
+			entry(name3, "", tar.TypeReg),
+		})
+		defer os.RemoveAll(sandbox)
+		checkSandbox(t, sandbox)
+	})
+}
+
+func TestExtractTarLayers(t *testing.T) {
+	file := func(name, contents string) *testTarEntry {
+		return &testTarEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))}, contents: contents}
+	}
+	dir := func(name string, mode int64) *testTarEntry {
+		return &testTarEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: mode}}
+	}
+	typed := func(name string, typ byte, link string) *testTarEntry {
+		return &testTarEntry{header: &tar.Header{Name: name, Typeflag: typ, Linkname: link, Mode: 0644}}
+	}
+	layers := [][]*testTarEntry{
+		{
+			file("a", "hello world"),
+			typed("h", tar.TypeLink, "a"),
+			dir("d/", 0755),
+			file("d/x", "x"),
+			file("f2d", "f"),
+			dir("d2f/", 0755),
+			file("d2f/y", "y"),
+			typed("s", tar.TypeSymlink, "a"),
+			typed("p", tar.TypeFifo, ""),
+			file("gone", "gone"),
+			dir("opq/", 0755),
+			file("opq/old", "old"),
+		},
+		{
+			file("a", "bye"),
+			typed("h", tar.TypeLink, "a"),
+			dir("d/", 0700),
+			dir("f2d/", 0755),
+			file("f2d/z", "z"),
+			file("d2f", "d2f"),
+			typed("s", tar.TypeSymlink, "d"),
+			typed("p", tar.TypeFifo, ""),
+			file(".wh.gone", ""),
+			file(".wh.never", ""),
+			dir("opq/", 0755),
+			file("opq/new", "new"),
+			file("opq/.wh..wh..opq", ""),
+		},
+	}
+
+	tmpdir, err := ioutil.TempDir("", "rocket-temp-dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	for i, entries := range layers {
+		testTarPath, err := newTestTar(entries)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer os.Remove(testTarPath)
+		if err := extractTestTar(t, testTarPath, tmpdir, Options{Whiteouts: true}); err != nil {
+			t.Fatalf("layer %d: unexpected error: %v", i, err)
+		}
+	}
+
+	files := map[string]string{
+		"a":       "bye",
+		"h":       "bye",
+		"d/x":     "x",
+		"f2d/z":   "z",
+		"d2f":     "d2f",
+		"opq/new": "new",
+	}
+	for name, contents := range files {
+		b, err := ioutil.ReadFile(filepath.Join(tmpdir, name))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if string(b) != contents {
+			t.Errorf("%s: unexpected contents: %q, wanted %q", name, b, contents)
+		}
+	}
+	for _, name := range []string{"gone", "opq/old", "opq/.wh..wh..opq", ".wh.gone", ".wh.never"} {
+		if _, err := os.Lstat(filepath.Join(tmpdir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected to be deleted, got %v", name, err)
+		}
+	}
+	if fi, err := os.Lstat(filepath.Join(tmpdir, "d")); err != nil || fi.Mode() != os.ModeDir|0700 {
+		t.Errorf("d: unexpected mode: %v, %v", fi, err)
+	}
+	if l, err := os.Readlink(filepath.Join(tmpdir, "s")); err != nil || l != "d" {
+		t.Errorf("s: unexpected link: %q, %v", l, err)
+	}
+	if fi, err := os.Lstat(filepath.Join(tmpdir, "p")); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("p: unexpected mode: %v, %v", fi, err)
+	}
+}
+
+func TestExtractTarWhiteouts(t *testing.T) {
+	file := func(name string) *testTarEntry {
+		return &testTarEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}}
+	}
+	extract := func(dir string, opts Options, entries ...*testTarEntry) {
+		testTarPath, err := newTestTar(entries)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer os.Remove(testTarPath)
+		if err := extractTestTar(t, testTarPath, dir, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	check := func(dir string, present, absent []string) {
+		for _, name := range present {
+			if _, err := os.Lstat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		for _, name := range absent {
+			if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+				t.Errorf("%s: expected to be deleted, got %v", name, err)
+			}
+		}
+	}
+	tmpdir, err := ioutil.TempDir("", "rocket-temp-dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	extract(tmpdir, Options{}, file("foo"), file("keep"), file("opq/a"), file("opq/b"))
+
+	// whiteouts are plain files unless asked for
+	extract(tmpdir, Options{}, file(".wh.foo"), file("opq/.wh..wh..opq"))
+	check(tmpdir, []string{"foo", ".wh.foo", "opq/a", "opq/b", "opq/.wh..wh..opq"}, nil)
+
+	// and only delete whitelisted paths
+	pwl := map[string]struct{}{"/foo": {}, "/opq/a": {}}
+	extract(tmpdir, Options{Whiteouts: true, PathWhitelist: pwl}, file(".wh.foo"), file(".wh.keep"), file("opq/.wh..wh..opq"))
+	check(tmpdir, []string{"keep", "opq/b"}, []string{"foo", "opq/a"})
+}
+
+func TestExtractTarPathWhitelist(t *testing.T) {
+	entries := []*testTarEntry{
+		{header: &tar.Header{Name: "rootfs/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{header: &tar.Header{Name: "rootfs/a", Mode: 0644, Size: 1}, contents: "a"},
+		{header: &tar.Header{Name: "rootfs/b/c", Mode: 0644, Size: 1}, contents: "c"},
+		{header: &tar.Header{Name: "rootfs/b/d", Mode: 0644, Size: 1}, contents: "d"},
+		{header: &tar.Header{Name: "rootfs/e", Typeflag: tar.TypeSymlink, Linkname: "a"}},
+	}
+	testTarPath, err := newTestTar(entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(testTarPath)
+	tmpdir, err := ioutil.TempDir("", "rocket-temp-dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	pwl := map[string]struct{}{
+		"/rootfs/a":   {},
+		"/rootfs/b/c": {},
+	}
+	if err := extractTestTar(t, testTarPath, tmpdir, Options{PathWhitelist: pwl}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"rootfs/a", "rootfs/b/c"} {
+		if _, err := os.Lstat(filepath.Join(tmpdir, name)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	for _, name := range []string{"rootfs/b/d", "rootfs/e"} {
+		if _, err := os.Lstat(filepath.Join(tmpdir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected not to be extracted, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package tar
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+)
+
+// setXattr sets the extended attribute attr of p, following symlinks
+func setXattr(p, attr, value string) error {
+	return syscall.Setxattr(p, attr, []byte(value), 0)
+}
+
+// getXattrs returns the extended attributes of p, if its file system
+// supports them
+func getXattrs(p string) (map[string]string, error) {
+	sz, err := syscall.Listxattr(p, nil)
+	if err == syscall.ENOTSUP || sz == 0 {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error listing xattrs of %q: %v", p, err)
+	}
+	buf := make([]byte, sz)
+	if sz, err = syscall.Listxattr(p, buf); err != nil {
+		return nil, fmt.Errorf("error listing xattrs of %q: %v", p, err)
+	}
+
+	xattrs := make(map[string]string)
+	for _, k := range bytes.Split(buf[:sz], []byte{0}) {
+		if len(k) == 0 {
+			continue
+		}
+		vsz, err := syscall.Getxattr(p, string(k), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error getting xattr %q of %q: %v", k, p, err)
+		}
+		v := make([]byte, vsz)
+		if vsz, err = syscall.Getxattr(p, string(k), v); err != nil {
+			return nil, fmt.Errorf("error getting xattr %q of %q: %v", k, p, err)
+		}
+		xattrs[string(k)] = string(v[:vsz])
+	}
+	return xattrs, nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package tar
+
+import (
+	"syscall"
+)
+
+// setXattr reports extended attributes as unsupported
+func setXattr(p, attr, value string) error {
+	return syscall.ENOTSUP
+}
+
+// getXattrs reports no extended attributes
+func getXattrs(p string) (map[string]string, error) {
+	return nil, nil
+}
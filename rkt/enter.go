@@ -0,0 +1,101 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/stage0"
+)
+
+const defaultEnterCmd = "/bin/sh"
+
+var (
+	flagEnterApp string
+	cmdEnter     = &Command{
+		Name:    "enter",
+		Summary: "Run a command in an app of a running container",
+		Usage:   "[--app=APP] UUID [CMD [ARGS...]]",
+		Description: `CMD is run in the namespaces of the container, with the rootfs of the app as
+its root. It defaults to ` + defaultEnterCmd + `. If the container runs several apps,
+the app must be chosen with --app. Like the app, CMD is limited to the
+capabilities of the app's isolators, and cannot gain new privileges unless the
+container was run with --allow-new-privileges.`,
+		Run: runEnter,
+	}
+)
+
+func init() {
+	commands = append(commands, cmdEnter)
+	cmdEnter.Flags.StringVar(&flagEnterApp, "app", "", "name of the app to enter")
+}
+
+func runEnter(args []string) (exit int) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "enter: Must provide a container UUID\n")
+		return 1
+	}
+
+	cdir, err := containerDir(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+		return 1
+	}
+
+	ra, err := enterApp(cdir, flagEnterApp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+		return 1
+	}
+
+	cmdline := args[1:]
+	if len(cmdline) == 0 {
+		cmdline = []string{defaultEnterCmd}
+	}
+
+	if err := stage0.Enter(cdir, ra.ImageID, cmdline); err != nil {
+		fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+		return 1
+	}
+	// not reached
+	return 0
+}
+
+// enterApp returns the app of the container rooted at cdir to enter: the one
+// named name, or the only one if name is empty
+func enterApp(cdir string, name string) (*schema.RuntimeApp, error) {
+	cm, err := loadContainerManifest(cdir)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		switch len(cm.Apps) {
+		case 0:
+			return nil, fmt.Errorf("container has no apps")
+		case 1:
+			return &cm.Apps[0], nil
+		default:
+			var names []string
+			for _, ra := range cm.Apps {
+				names = append(names, ra.Name.String())
+			}
+			return nil, fmt.Errorf("container has several apps, choose one with --app: %s", strings.Join(names, ", "))
+		}
+	}
+
+	n, err := types.NewACName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid app name %q: %v", name, err)
+	}
+	ra := cm.Apps.Get(*n)
+	if ra == nil {
+		return nil, fmt.Errorf("container has no app %q", name)
+	}
+	return ra, nil
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/appc/spec/discovery"
 	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/cas"
+	pio "github.com/coreos/rocket/pkg/io"
 )
 
 const (
@@ -16,23 +21,76 @@ const (
 )
 
 var (
-	cmdFetch = &Command{
+	flagLimitRate    rate
+	flagNoProgress   bool
+	flagJSONProgress bool
+	cmdFetch         = &Command{
 		Name:    "fetch",
 		Summary: "Fetch image(s) and store them in the local cache",
-		Usage:   "IMAGE_URL...",
-		Run:     runFetch,
+		Usage:   "[--limit-rate=RATE] [--no-progress] [--json-progress] IMAGE_URL...",
+		Description: `The progress of downloads is drawn on stderr when it is a terminal, or
+reported there as lines of JSON with --json-progress.`,
+		Run: runFetch,
 	}
 )
 
 func init() {
 	commands = append(commands, cmdFetch)
+	cmdFetch.Flags.Var(&flagLimitRate, "limit-rate", "maximum download rate in bytes per second, with an optional K, M or G suffix")
+	cmdFetch.Flags.BoolVar(&flagNoProgress, "no-progress", false, "do not report the progress of downloads")
+	cmdFetch.Flags.BoolVar(&flagJSONProgress, "json-progress", false, "report the progress of downloads as lines of JSON, for machines")
+}
+
+// rate implements the flag.Value interface to contain a rate in bytes per
+// second, like 500K
+type rate int64
+
+func (r *rate) Set(s string) error {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return errors.New("rate must be a positive number of bytes, with an optional K, M or G suffix")
+	}
+	if n > math.MaxInt64/mult {
+		return errors.New("rate is too large")
+	}
+	*r = rate(n * mult)
+	return nil
+}
+
+func (r *rate) String() string {
+	return strconv.FormatInt(int64(*r), 10)
+}
+
+// downloadOptions returns how to download images, according to the flags
+func downloadOptions() cas.DownloadOptions {
+	opts := cas.DownloadOptions{LimitRate: int64(flagLimitRate)}
+	switch {
+	case flagNoProgress:
+	case flagJSONProgress:
+		opts.Progress = pio.JSONProgress(os.Stderr)
+	case pio.IsTerminal(os.Stderr):
+		opts.Progress = pio.TerminalProgress(os.Stderr)
+	}
+	return opts
 }
 
 func fetchURL(img string, ds *cas.Store) (string, error) {
 	rem := cas.NewRemote(img, []string{})
 	err := ds.ReadIndex(rem)
 	if err != nil && rem.Blob == "" {
-		rem, err = rem.Download(*ds)
+		rem, err = rem.Download(*ds, downloadOptions())
 		if err != nil {
 			return "", fmt.Errorf("downloading: %v\n", err)
 		}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRateSet(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"500", 500, false},
+		{"500K", 500 << 10, false},
+		{"2M", 2 << 20, false},
+		{"1G", 1 << 30, false},
+		{"0", 0, true},
+		{"-1K", 0, true},
+		{"K", 0, true},
+		{"9223372036854775807", 9223372036854775807, false},
+		{"8589934592G", 0, true},
+		{"9223372036854775807K", 0, true},
+	}
+	for _, tt := range tests {
+		var r rate
+		err := r.Set(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if err == nil && int64(r) != tt.want {
+			t.Errorf("%q: got %d, wanted %d", tt.in, r, tt.want)
+		}
+	}
+}
@@ -1,39 +1,102 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/proc"
+	"github.com/coreos/rocket/pkg/status"
+	"github.com/coreos/rocket/stage1/mds"
+	"github.com/coreos/rocket/stage1/networking"
 )
 
 const (
-	defaultGracePeriod = 30 * time.Minute
+	defaultGracePeriod        = 30 * time.Minute
+	defaultAbortedGracePeriod = 10 * time.Minute
+	defaultExitedGracePeriod  = 0
+
+	// discardLockTimeout bounds the wait for the lock of a collected
+	// container, which a process may still hold
+	discardLockTimeout = 10 * time.Second
 )
 
 var (
-	flagGracePeriod time.Duration
-	cmdGC           = &Command{
+	flagGracePeriod        time.Duration
+	flagAbortedGracePeriod time.Duration
+	flagExitedGracePeriod  time.Duration
+	flagGCDryRun           bool
+	flagGCJSON             bool
+	flagGCForce            bool
+	cmdGC                  = &Command{
 		Name:    "gc",
 		Summary: "Garbage-collect rkt containers no longer in use",
-		Usage:   "[--grace-period=duration]",
-		Run:     runGC,
+		Usage:   "[--grace-period=duration] [--aborted-grace-period=duration] [--exited-grace-period=duration] [--dry-run] [--json] [--force]",
+		Description: `Containers which are not running are moved to the garbage once they have
+been in their state for its grace period:
+  aborted: failed to be set up, or to start
+  exited:  ran, and are done
+Containers are discarded from the garbage after --grace-period, once their
+mounts and networking are torn down, unless a process still uses them or
+they are still mounted elsewhere.`,
+		Run: runGC,
 	}
 )
 
 func init() {
 	commands = append(commands, cmdGC)
 	cmdGC.Flags.DurationVar(&flagGracePeriod, "grace-period", defaultGracePeriod, "duration to wait before discarding inactive containers from garbage")
+	cmdGC.Flags.DurationVar(&flagAbortedGracePeriod, "aborted-grace-period", defaultAbortedGracePeriod, "duration to wait before collecting containers which failed to be set up or to start")
+	cmdGC.Flags.DurationVar(&flagExitedGracePeriod, "exited-grace-period", defaultExitedGracePeriod, "duration to wait before collecting containers which exited")
+	cmdGC.Flags.BoolVar(&flagGCDryRun, "dry-run", false, "only report what would be done")
+	cmdGC.Flags.BoolVar(&flagGCJSON, "json", false, "print a summary in JSON instead of progress messages")
+	cmdGC.Flags.BoolVar(&flagGCForce, "force", false, "discard containers even if processes or mounts still use them")
+}
+
+// gcResult reports what gc did, or would do, with a container
+type gcResult struct {
+	UUID string `json:"uuid"`
+	// State is running, aborted or exited for containers, and
+	// garbage for containers already collected
+	State string `json:"state"`
+	// Action is kept, collected (moved to the garbage), discarded or
+	// failed
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type gcSummary struct {
+	DryRun     bool       `json:"dryRun"`
+	Containers []gcResult `json:"containers"`
+}
+
+// gcOut receives the progress messages, which would mix with the summary on
+// stdout
+func gcOut() io.Writer {
+	if flagGCJSON {
+		return os.Stderr
+	}
+	return os.Stdout
 }
 
 func runGC(args []string) (exit int) {
-	if err := os.MkdirAll(garbageDir(), 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to create garbage dir: %v\n", err)
-		return 1
+	sum := gcSummary{DryRun: flagGCDryRun}
+
+	if !flagGCDryRun {
+		if err := os.MkdirAll(garbageDir(), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to create garbage dir: %v\n", err)
+			return 1
+		}
 	}
 
 	cs, err := getContainers()
@@ -42,29 +105,96 @@ func runGC(args []string) (exit int) {
 		return 1
 	}
 	for _, c := range cs {
-		cp := filepath.Join(containersDir(), c)
-		l, err := lock.TryExclusiveLock(cp)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to open lock, ignoring %q: %v\n", c, err)
-			continue
+		sum.Containers = append(sum.Containers, collectContainer(c))
+	}
+
+	// clean up anything old in the garbage dir
+	gs, err := emptyGarbage(flagGracePeriod)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to empty garbage: %v\n", err)
+		exit = 1
+	}
+	sum.Containers = append(sum.Containers, gs...)
+
+	for _, r := range sum.Containers {
+		if r.Action == "failed" {
+			exit = 1
 		}
+	}
 
-		fmt.Printf("Moving container %q to garbage\n", c)
-		err = os.Rename(cp, filepath.Join(garbageDir(), c))
-		if err != nil {
-			fmt.Println(err)
+	if flagGCJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(sum); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to encode summary: %v\n", err)
+			return 1
 		}
-		l.Close()
 	}
+	return
+}
 
-	// clean up anything old in the garbage dir
-	err = emptyGarbage(flagGracePeriod)
+// collectContainer moves the container c to the garbage if it is not
+// running and its grace period is over
+func collectContainer(c string) gcResult {
+	r := gcResult{UUID: c, Action: "kept"}
+	fail := func(format string, args ...interface{}) gcResult {
+		r.Action = "failed"
+		r.Error = fmt.Sprintf(format, args...)
+		fmt.Fprintf(os.Stderr, "Unable to collect container %q: %s\n", c, r.Error)
+		return r
+	}
+
+	cp := filepath.Join(containersDir(), c)
+	l, err := lock.TryExclusiveLock(cp)
+	if err == lock.ErrLocked {
+		r.State = "running"
+		return r
+	}
 	if err != nil {
-		fmt.Println(err)
-		return 1
+		return fail("error opening lock: %v", err)
 	}
+	defer l.Close()
 
-	return
+	var ids []types.Hash
+	if cm, err := loadContainerManifest(cp); err == nil {
+		for _, ra := range cm.Apps {
+			ids = append(ids, ra.ImageID)
+		}
+	}
+	st, since, err := status.ReadState(cp, ids)
+	if err != nil {
+		return fail("error reading state: %v", err)
+	}
+	r.State = string(st)
+
+	var grace time.Duration
+	switch st {
+	case status.Aborted:
+		grace = flagAbortedGracePeriod
+	case status.Exited:
+		grace = flagExitedGracePeriod
+	}
+	if left := grace - time.Since(since); left > 0 {
+		r.Reason = fmt.Sprintf("%s for %v more", st, left/time.Second*time.Second)
+		return r
+	}
+
+	r.Action = "collected"
+	if flagGCDryRun {
+		fmt.Fprintf(gcOut(), "Would move %s container %q to garbage\n", st, c)
+		return r
+	}
+	fmt.Fprintf(gcOut(), "Moving %s container %q to garbage\n", st, c)
+	gp := filepath.Join(garbageDir(), c)
+	if err := os.Rename(cp, gp); err != nil {
+		return fail("error moving to garbage: %v", err)
+	}
+	// both retried before the container is discarded
+	if err := deregister(gp, c); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to deregister container %q from the metadata service: %v\n", c, err)
+	}
+	if err := networking.Teardown(gp, c); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to tear down networking of container %q: %v\n", c, err)
+	}
+	return r
 }
 
 // getContainers returns a slice representing the containers in the given rocket directory
@@ -72,12 +202,15 @@ func getContainers() ([]string, error) {
 	cdir := containersDir()
 	ls, err := ioutil.ReadDir(cdir)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("cannot read containers directory: %v", err)
 	}
 	var cs []string
 	for _, dir := range ls {
 		if !dir.IsDir() {
-			fmt.Fprintf(os.Stderr, "Unrecognized file: %q, ignoring", dir)
+			fmt.Fprintf(os.Stderr, "Unrecognized file: %q, ignoring\n", dir.Name())
 			continue
 		}
 		cs = append(cs, dir.Name())
@@ -86,37 +219,151 @@ func getContainers() ([]string, error) {
 }
 
 // emptyGarbage discards sufficiently aged containers from garbageDir()
-func emptyGarbage(gracePeriod time.Duration) error {
+func emptyGarbage(gracePeriod time.Duration) ([]gcResult, error) {
 	g := garbageDir()
 
 	ls, err := ioutil.ReadDir(g)
 	if err != nil {
-		return err
+		if os.IsNotExist(err) && flagGCDryRun {
+			return nil, nil
+		}
+		return nil, err
 	}
 
+	var rs []gcResult
 	for _, dir := range ls {
 		gp := filepath.Join(g, dir.Name())
 		st := &syscall.Stat_t{}
 		err := syscall.Lstat(gp, st)
 		if err != nil {
 			if err != syscall.ENOENT {
-				fmt.Fprintf(os.Stderr, "Unable to stat %q, ignoring: %v", gp, err)
+				fmt.Fprintf(os.Stderr, "Unable to stat %q, ignoring: %v\n", gp, err)
 			}
 			continue
 		}
 
+		r := gcResult{UUID: dir.Name(), State: "garbage", Action: "kept"}
 		expiration := time.Unix(st.Ctim.Unix()).Add(gracePeriod)
-		if time.Now().After(expiration) {
-			l, err := lock.ExclusiveLock(gp)
-			if err != nil {
-				continue
-			}
-			fmt.Printf("Garbage collecting container %q\n", dir.Name())
-			if err = os.RemoveAll(gp); err != nil {
-				fmt.Fprintf(os.Stderr, "Unable to remove container %q: %v\n", dir.Name(), err)
-			}
-			l.Close()
+		if left := expiration.Sub(time.Now()); left > 0 {
+			r.Reason = fmt.Sprintf("garbage for %v more", left/time.Second*time.Second)
+		} else if err := discardContainer(gp, dir.Name()); err != nil {
+			r.Action = "failed"
+			r.Error = err.Error()
+			fmt.Fprintf(os.Stderr, "Unable to discard container %q: %v\n", dir.Name(), err)
+		} else {
+			r.Action = "discarded"
 		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// discardContainer removes the collected container c rooted at gp, after
+// tearing down what it may have left behind on the host
+func discardContainer(gp, c string) error {
+	if flagGCDryRun {
+		fmt.Fprintf(gcOut(), "Would garbage collect container %q\n", c)
+		return nil
+	}
+
+	// a collected container whose lock is still held, say by a wedged
+	// stage1, is left for the next run, naming the holders
+	l, err := lock.ExclusiveLockTimeout(gp, discardLockTimeout)
+	if err != nil {
+		return fmt.Errorf("error taking lock: %v", err)
+	}
+	defer l.Close()
+
+	gp, err = filepath.Abs(gp)
+	if err != nil {
+		return err
+	}
+	// nothing is unmounted until the container is known to be unused, so
+	// that processes still relying on it are left alone
+	if err := checkUnused(gp); err != nil {
+		if !flagGCForce {
+			return fmt.Errorf("%v; use --force to discard it anyway", err)
+		}
+		fmt.Fprintf(os.Stderr, "Discarding container %q anyway: %v\n", c, err)
+	}
+	if err := unmountAll(gp); err != nil {
+		return err
+	}
+	if err := deregister(gp, c); err != nil {
+		return fmt.Errorf("error deregistering from the metadata service: %v", err)
+	}
+	if err := networking.Teardown(gp, c); err != nil {
+		return fmt.Errorf("error tearing down networking: %v", err)
+	}
+	fmt.Fprintf(gcOut(), "Garbage collecting container %q\n", c)
+	if err := os.RemoveAll(gp); err != nil {
+		return fmt.Errorf("error removing: %v", err)
+	}
+	return nil
+}
+
+// deregister removes the container c rooted at gp from the metadata service
+// stage1 registered it with, if any
+func deregister(gp, c string) error {
+	uid, err := types.NewUUID(c)
+	if err != nil {
+		return err
+	}
+	return mds.Deregister(gp, *uid)
+}
+
+// unmountAll unmounts everything mounted at or below dir, deepest first
+func unmountAll(dir string) error {
+	ms, err := proc.Mounts()
+	if err != nil {
+		return fmt.Errorf("error reading mounts: %v", err)
+	}
+	var mps []string
+	for _, m := range proc.MountsUnder(ms, dir) {
+		mps = append(mps, m.MountPoint)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(mps)))
+	for _, mp := range mps {
+		if err := syscall.Unmount(mp, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+			return fmt.Errorf("error unmounting %q: %v", mp, err)
+		}
+	}
+	return nil
+}
+
+// checkUnused fails if dir is still used by a process, or is the source of a
+// mount, reporting the culprits
+func checkUnused(dir string) error {
+	ms, err := proc.Mounts()
+	if err != nil {
+		return fmt.Errorf("error reading mounts: %v", err)
+	}
+	var binds []string
+	for _, m := range proc.BindsFrom(ms, dir) {
+		binds = append(binds, m.MountPoint)
+	}
+	if len(binds) > 0 {
+		return fmt.Errorf("still mounted at %s", strings.Join(binds, ", "))
+	}
+
+	u, err := proc.LiveProcs(dir)
+	if err != nil {
+		return fmt.Errorf("error looking for processes using it: %v", err)
+	}
+	if len(u.ByPid) > 0 {
+		var pids []int
+		for pid := range u.ByPid {
+			pids = append(pids, pid)
+		}
+		sort.Ints(pids)
+		var ss []string
+		for _, pid := range pids {
+			ss = append(ss, fmt.Sprintf("%d (%s)", pid, strings.Join(u.ByPid[pid], ", ")))
+		}
+		return fmt.Errorf("still in use by pids %s", strings.Join(ss, "; "))
+	}
+	if !u.Complete {
+		return fmt.Errorf("unable to inspect all processes")
 	}
 	return nil
 }
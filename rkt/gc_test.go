@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/coreos/rocket/pkg/proc"
+)
+
+func TestDiscardContainerInUse(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounts can only be created as root")
+	}
+
+	src, err := ioutil.TempDir("", "rkt-gc-src")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(src)
+	gp, err := ioutil.TempDir("", "rkt-gc")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(gp)
+
+	mp := filepath.Join(gp, "stage1/rootfs/opt/stage2")
+	if err := os.MkdirAll(mp, 0755); err != nil {
+		t.Fatalf("error creating mount point: %v", err)
+	}
+	if err := syscall.Mount(src, mp, "", syscall.MS_BIND, ""); err != nil {
+		t.Skipf("unable to bind mount: %v", err)
+	}
+	defer syscall.Unmount(mp, syscall.MNT_DETACH)
+
+	cmd := exec.Command("sleep", "60")
+	cmd.Dir = mp
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("error starting process: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	flagGCForce = false
+	if err := discardContainer(gp, "test"); err == nil {
+		t.Fatalf("container in use discarded")
+	}
+
+	if _, err := os.Stat(gp); err != nil {
+		t.Errorf("container removed: %v", err)
+	}
+	ms, err := proc.Mounts()
+	if err != nil {
+		t.Fatalf("error reading mounts: %v", err)
+	}
+	if len(proc.MountsUnder(ms, mp)) == 0 {
+		t.Errorf("container unmounted while in use")
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"text/tabwriter"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/path"
 )
 
 const (
@@ -97,3 +103,37 @@ func containersDir() string {
 func garbageDir() string {
 	return filepath.Join(globalFlags.Dir, "garbage")
 }
+
+// containerDir returns the directory of the container with the given UUID,
+// looking in the garbage if it is no longer among the containers
+func containerDir(id string) (string, error) {
+	uuid, err := types.NewUUID(id)
+	if err != nil {
+		return "", fmt.Errorf("invalid container UUID %q: %v", id, err)
+	}
+	for _, d := range []string{containersDir(), garbageDir()} {
+		cdir := filepath.Join(d, uuid.String())
+		if _, err := os.Stat(cdir); err == nil {
+			return cdir, nil
+		}
+	}
+	return "", fmt.Errorf("container %q not found", id)
+}
+
+// loadContainerManifest returns the runtime manifest of the container rooted
+// at cdir
+func loadContainerManifest(cdir string) (*schema.ContainerRuntimeManifest, error) {
+	b, err := ioutil.ReadFile(path.ContainerManifestPath(cdir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading container manifest: %v", err)
+	}
+	cm := &schema.ContainerRuntimeManifest{}
+	if err := json.Unmarshal(b, cm); err != nil {
+		return nil, fmt.Errorf("error unmarshaling container manifest: %v", err)
+	}
+	return cm, nil
+}
+
+func netDataDir() string {
+	return filepath.Join(globalFlags.Dir, "net")
+}
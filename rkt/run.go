@@ -1,4 +1,5 @@
-//+build linux
+//go:build linux
+// +build linux
 
 package main
 
@@ -8,23 +9,34 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/cas"
 	"github.com/coreos/rocket/stage0"
+	"github.com/coreos/rocket/stage1/mds"
+	"github.com/coreos/rocket/stage1/networking"
 )
 
 var (
-	flagStage1Init   string
-	flagStage1Rootfs string
-	flagVolumes      volumeMap
-	cmdRun           = &Command{
+	flagStage1Init    string
+	flagStage1Rootfs  string
+	flagVolumes       volumeMap
+	flagAllowNewPrivs bool
+	flagPrivateNet    bool
+	flagNets          netList
+	flagPorts         networking.ForwardedPorts
+	flagMDSRegister   bool
+	flagMDSSocket     string
+	cmdRun            = &Command{
 		Name:    "run",
 		Summary: "Run image(s) in an application container in rocket",
-		Usage:   "[--volume LABEL:SOURCE] IMAGE...",
+		Usage:   "[--volume LABEL:SOURCE] [--allow-new-privileges] [--private-net] [--net=NAME[,NAME...]] [--port=NAME:HOSTPORT] [--mds-register] [--mds-socket=PATH] IMAGE...",
 		Description: `IMAGE should be a string referencing an image; either a hash, local file on disk, or URL.
-They will be checked in that order and the first match will be used.`,
+They will be checked in that order and the first match will be used.
+Forwarding ports of apps to the host with --port and registering with the
+metadata service with --mds-register imply --private-net.`,
 		Run: runRun,
 	}
 )
@@ -34,6 +46,12 @@ func init() {
 	cmdRun.Flags.StringVar(&flagStage1Init, "stage1-init", "", "path to stage1 binary override")
 	cmdRun.Flags.StringVar(&flagStage1Rootfs, "stage1-rootfs", "", "path to stage1 rootfs tarball override")
 	cmdRun.Flags.Var(&flagVolumes, "volume", "volumes to mount into the shared container environment")
+	cmdRun.Flags.BoolVar(&flagAllowNewPrivs, "allow-new-privileges", false, "allow apps to gain new privileges (e.g. via setuid binaries); use only with trusted images")
+	cmdRun.Flags.BoolVar(&flagPrivateNet, "private-net", false, "give the container a private network namespace attached to the default network")
+	cmdRun.Flags.Var(&flagNets, "net", "comma-separated list of networks (configured in "+networking.ConfDir+") to attach a private network namespace to")
+	cmdRun.Flags.Var(&flagPorts, "port", "port of an app, named as in its image manifest, to forward from the host to the container")
+	cmdRun.Flags.BoolVar(&flagMDSRegister, "mds-register", false, "register the container with the metadata service (metadatasvc must be running)")
+	cmdRun.Flags.StringVar(&flagMDSSocket, "mds-socket", mds.DefaultSocket, "socket the metadata service serves its registration API on, as set with its --registration-socket")
 	flagVolumes = volumeMap{}
 }
 
@@ -120,13 +138,38 @@ func runRun(args []string) (exit int) {
 		Stage1Rootfs:  flagStage1Rootfs,
 		Images:        imgs,
 		Volumes:       flagVolumes,
+		AllowNewPrivs: flagAllowNewPrivs,
+	}
+	if (flagPrivateNet || len(flagPorts) > 0 || flagMDSRegister) && len(flagNets) == 0 {
+		flagNets = netList{networking.DefaultNetName}
+	}
+	if len(flagNets) > 0 {
+		// stage1 runs from within the container directory
+		dir, err := filepath.Abs(netDataDir())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "run: error resolving network data directory: %v\n", err)
+			return 1
+		}
+		cfg.PrivateNets = flagNets
+		cfg.NetDataDir = dir
+		cfg.Ports = flagPorts
+		cfg.MDSRegister = flagMDSRegister
+	}
+	if cfg.MDSRegister {
+		// stage1 runs from within the container directory
+		sock, err := filepath.Abs(flagMDSSocket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "run: error resolving metadata service socket: %v\n", err)
+			return 1
+		}
+		cfg.MDSSocket = sock
 	}
 	cdir, err := stage0.Setup(cfg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "run: error setting up stage0: %v\n", err)
 		return 1
 	}
-	stage0.Run(cdir, cfg.Debug) // execs, never returns
+	stage0.Run(cfg, cdir) // execs, never returns
 	return 1
 }
 
@@ -154,3 +197,21 @@ func (vm *volumeMap) String() string {
 	}
 	return strings.Join(ss, ",")
 }
+
+// netList implements the flag.Value interface to contain a list of network
+// names
+type netList []string
+
+func (nl *netList) Set(s string) error {
+	for _, n := range strings.Split(s, ",") {
+		if n == "" {
+			return errors.New("network names must not be empty")
+		}
+		*nl = append(*nl, n)
+	}
+	return nil
+}
+
+func (nl *netList) String() string {
+	return strings.Join(*nl, ",")
+}
@@ -1,8 +1,16 @@
-//+build linux
+//go:build linux
+// +build linux
 
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+
+	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/status"
+	"github.com/coreos/rocket/stage1/networking"
+)
 
 var (
 	cmdStatus = &Command{
@@ -18,6 +26,74 @@ func init() {
 }
 
 func runStatus(args []string) (exit int) {
-	fmt.Println("Not implemented.")
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "status: Must provide a container UUID\n")
+		return 1
+	}
+
+	cdir, err := containerDir(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status: %v\n", err)
+		return 1
+	}
+
+	cm, err := loadContainerManifest(cdir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status: %v\n", err)
+		return 1
+	}
+
+	// a running container holds an exclusive lock on its directory
+	running := false
+	l, err := lock.TrySharedLock(cdir)
+	switch {
+	case err == nil:
+		l.Close()
+	case err == lock.ErrLocked:
+		running = true
+	default:
+		fmt.Fprintf(os.Stderr, "status: unable to check whether container is running: %v\n", err)
+		return 1
+	}
+
+	pid, err := status.ReadPid(cdir)
+	switch {
+	case err == nil:
+		fmt.Fprintf(out, "pid=%d\n", pid)
+	case os.IsNotExist(err):
+	default:
+		fmt.Fprintf(os.Stderr, "status: unable to read pid: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintln(out, "APP\tIMAGE\tSTATUS")
+	for _, ra := range cm.Apps {
+		st := "unknown"
+		es, err := status.ReadExitStatus(cdir, ra.ImageID)
+		switch {
+		case err == nil:
+			st = fmt.Sprintf("exited %d", es)
+		case os.IsNotExist(err) && running:
+			st = "running"
+		case !os.IsNotExist(err):
+			fmt.Fprintf(os.Stderr, "status: unable to read exit status of %q: %v\n", ra.Name, err)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\n", ra.Name, ra.ImageID, st)
+	}
+	out.Flush()
+
+	nis, err := networking.LoadNetInfo(cdir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status: unable to load network info: %v\n", err)
+		return 1
+	}
+	if len(nis) > 0 {
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "NETWORK\tINTERFACE\tADDRESS")
+		for _, ni := range nis {
+			fmt.Fprintf(out, "%s\t%s\t%v/%d\n", ni.Name, ni.IfName, ni.IP, ni.PrefixLen)
+		}
+		out.Flush()
+	}
 	return
 }
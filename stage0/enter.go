@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package stage0
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/status"
+)
+
+const (
+	// Path to the enter helper within the stage1 rootfs
+	enterBin = "/enter"
+	// Path to the interpreter within the stage1 rootfs
+	interpBin = "/usr/lib/ld-linux-x86-64.so.2"
+)
+
+// ContainerPid returns the pid of the init of the container rooted at dir,
+// failing if the container is not running. A running container holds an
+// exclusive lock on its directory.
+func ContainerPid(dir string) (int, error) {
+	l, err := lock.TrySharedLock(dir)
+	switch {
+	case err == nil:
+		l.Close()
+		return 0, fmt.Errorf("container is not running")
+	case err != lock.ErrLocked:
+		return 0, fmt.Errorf("error checking whether container is running: %v", err)
+	}
+
+	pid, err := status.ReadPid(dir)
+	if err != nil {
+		return 0, fmt.Errorf("error reading pid: %v", err)
+	}
+	return pid, nil
+}
+
+// Enter replaces the current process with cmdline, run in the app with the
+// given image ID of the running container rooted at dir, under the
+// isolators of the app. It only returns on error.
+func Enter(dir string, imageID types.Hash, cmdline []string) error {
+	pid, err := ContainerPid(dir)
+	if err != nil {
+		return err
+	}
+
+	s1 := rktpath.Stage1RootfsPath(dir)
+	args := []string{
+		filepath.Join(s1, interpBin),
+		filepath.Join(s1, enterBin),
+		strconv.Itoa(pid),
+		rktpath.RelAppRootfsPath(imageID),
+		rktpath.RelAppIsolatorsPath(imageID),
+	}
+	args = append(args, cmdline...)
+
+	env := os.Environ()
+	env = append(env, "LD_LIBRARY_PATH="+filepath.Join(s1, "usr/lib"))
+
+	if err := syscall.Exec(args[0], args, env); err != nil {
+		return fmt.Errorf("error execing enter: %v", err)
+	}
+	return nil
+}
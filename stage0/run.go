@@ -1,4 +1,5 @@
-//+build linux
+//go:build linux
+// +build linux
 
 package stage0
 
@@ -22,6 +23,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/appc/spec/aci"
@@ -30,8 +32,11 @@ import (
 	"github.com/coreos/rocket/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid"
 	"github.com/coreos/rocket/cas"
 	rktpath "github.com/coreos/rocket/path"
+	pio "github.com/coreos/rocket/pkg/io"
 	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/status"
 	ptar "github.com/coreos/rocket/pkg/tar"
+	"github.com/coreos/rocket/stage1/networking"
 	"github.com/coreos/rocket/version"
 
 	"github.com/coreos/rocket/stage0/stage1_init"
@@ -49,6 +54,19 @@ type Config struct {
 	Stage1Init    string     // binary to be execed as stage1
 	Stage1Rootfs  string     // compressed bundle containing a rootfs for stage1
 	Debug         bool
+	// AllowNewPrivs permits apps to gain new privileges (e.g. via setuid binaries)
+	AllowNewPrivs bool
+	// PrivateNets lists the networks to attach the container to; if empty,
+	// the container shares the host's network
+	PrivateNets []string
+	NetDataDir  string // directory for network plugins to keep their state in
+	// Ports lists the ports of apps to forward from the host; they require
+	// a private network
+	Ports networking.ForwardedPorts
+	// MDSRegister registers the container with the metadata service; it
+	// requires a private network
+	MDSRegister bool
+	MDSSocket   string // socket of the metadata service, to register through
 	// TODO(jonboulle): These images are partially-populated hashes, this should be clarified.
 	Images  []types.Hash      // application images
 	Volumes map[string]string // map of volumes that rocket can provide to applications
@@ -133,11 +151,13 @@ func Setup(cfg Config) (string, error) {
 	}
 	cm.ACVersion = *v
 
+	var ams []*schema.ImageManifest
 	for _, img := range cfg.Images {
 		am, err := setupImage(cfg, img, dir)
 		if err != nil {
 			return "", fmt.Errorf("error setting up image %s: %v", img, err)
 		}
+		ams = append(ams, am)
 		if cm.Apps.Get(am.Name) != nil {
 			return "", fmt.Errorf("error: multiple apps with name %s", am.Name)
 		}
@@ -150,6 +170,16 @@ func Setup(cfg Config) (string, error) {
 		cm.Apps = append(cm.Apps, a)
 	}
 
+	if len(cfg.Ports) > 0 && len(cfg.PrivateNets) == 0 {
+		return "", fmt.Errorf("error: forwarding ports requires a private network")
+	}
+	if cfg.MDSRegister && len(cfg.PrivateNets) == 0 {
+		return "", fmt.Errorf("error: registering with the metadata service requires a private network")
+	}
+	if _, err := networking.ResolvePorts(cfg.Ports, ams); err != nil {
+		return "", fmt.Errorf("error forwarding ports: %v", err)
+	}
+
 	var sVols []types.Volume
 	for key, path := range cfg.Volumes {
 		v := types.Volume{
@@ -181,7 +211,11 @@ func Setup(cfg Config) (string, error) {
 
 // Run actually runs the container by exec()ing the stage1 init inside
 // the container filesystem.
-func Run(dir string, debug bool) {
+func Run(cfg Config, dir string) {
+	if err := status.MarkRun(dir); err != nil {
+		log.Fatalf("error recording run: %v", err)
+	}
+
 	log.Printf("Pivoting to filesystem %s", dir)
 	if err := os.Chdir(dir); err != nil {
 		log.Fatalf("failed changing to dir: %v", err)
@@ -189,8 +223,24 @@ func Run(dir string, debug bool) {
 
 	log.Printf("Execing %s", initPath)
 	args := []string{initPath}
-	if debug {
-		args = append(args, "debug")
+	if cfg.Debug {
+		args = append(args, "--debug")
+	}
+	if cfg.AllowNewPrivs {
+		args = append(args, "--allow-new-privileges")
+	}
+	if len(cfg.PrivateNets) > 0 {
+		args = append(args, "--net="+strings.Join(cfg.PrivateNets, ","))
+		args = append(args, "--net-data-dir="+cfg.NetDataDir)
+	}
+	if cfg.MDSRegister {
+		args = append(args, "--mds-register")
+		if cfg.MDSSocket != "" {
+			args = append(args, "--mds-socket="+cfg.MDSSocket)
+		}
+	}
+	for _, fp := range cfg.Ports {
+		args = append(args, "--port="+fp.String())
 	}
 	if err := syscall.Exec(initPath, args, os.Environ()); err != nil {
 		log.Fatalf("error execing init: %v", err)
@@ -207,16 +257,24 @@ func lockDir(dir string) error {
 	if err != nil {
 		panic(err)
 	}
+	if err := l.SetInheritable(true); err != nil {
+		return fmt.Errorf("error passing lock on dir %q to stage1: %v", dir, err)
+	}
 	return os.Setenv(envLockFd, fmt.Sprintf("%v", fd))
 }
 
+// warnTar reports what could not be restored extracting a tarball
+func warnTar(err error) {
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+}
+
 func untarRootfs(r io.Reader, dir string) error {
 	tr := tar.NewReader(r)
 	if err := os.MkdirAll(dir, 0776); err != nil {
 		return fmt.Errorf("error creating stage1 rootfs directory: %v", err)
 	}
 
-	if err := ptar.ExtractTar(tr, dir); err != nil {
+	if err := ptar.ExtractTarWithOptions(tr, dir, ptar.Options{Warn: warnTar}); err != nil {
 		return fmt.Errorf("error extracting rootfs: %v", err)
 	}
 	return nil
@@ -287,20 +345,19 @@ func setupImage(cfg Config, img types.Hash, dir string) (*schema.ImageManifest,
 		return nil, fmt.Errorf("error creating image directory: %v", err)
 	}
 
-	hash := sha512.New()
-	r := io.TeeReader(rs, hash)
+	r := pio.NewHashingReader(rs, sha512.New())
 
-	if err := ptar.ExtractTar(tar.NewReader(r), ad); err != nil {
+	if err := ptar.ExtractTarWithOptions(tar.NewReader(r), ad, ptar.Options{Warn: warnTar}); err != nil {
 		return nil, fmt.Errorf("error extracting ACI: %v", err)
 	}
 
 	// Tar does not necessarily read the complete file, so ensure we read the entirety into the hash
-	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+	if err := r.Drain(); err != nil {
 		return nil, fmt.Errorf("error reading ACI: %v", err)
 	}
 
 	// TODO(jonboulle): clean this up, leaky abstraction with the store.
-	if g := cas.HashToKey(hash); g != img.String() {
+	if g := cas.HashToKey(r.H); g != img.String() {
 		if err := os.RemoveAll(ad); err != nil {
 			fmt.Fprintf(os.Stderr, "error cleaning up directory: %v\n", err)
 		}
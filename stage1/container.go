@@ -1,4 +1,5 @@
-//+build linux
+//go:build linux
+// +build linux
 
 package main
 
@@ -66,21 +67,22 @@ func LoadContainer(root string) (*Container, error) {
 }
 
 // appToSystemd transforms the provided app manifest into systemd units
-func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error {
+func (c *Container) appToSystemd(am *schema.ImageManifest, ra *schema.RuntimeApp, allowNewPrivs bool) error {
 	name := am.Name.String()
+	id := ra.ImageID
 	app := am.App
 	execStart := strings.Join(app.Exec, " ")
 	opts := []*unit.UnitOption{
-		&unit.UnitOption{"Unit", "Description", name},
-		&unit.UnitOption{"Unit", "DefaultDependencies", "false"},
-		&unit.UnitOption{"Unit", "OnFailureJobMode", "isolate"},
-		&unit.UnitOption{"Unit", "OnFailure", "reaper.service"},
-		&unit.UnitOption{"Unit", "Wants", "exit-watcher.service"},
-		&unit.UnitOption{"Service", "Restart", "no"},
-		&unit.UnitOption{"Service", "RootDirectory", rktpath.RelAppRootfsPath(id)},
-		&unit.UnitOption{"Service", "ExecStart", execStart},
-		&unit.UnitOption{"Service", "User", app.User},
-		&unit.UnitOption{"Service", "Group", app.Group},
+		newUnitOption("Unit", "Description", name),
+		newUnitOption("Unit", "DefaultDependencies", "false"),
+		newUnitOption("Unit", "OnFailureJobMode", "isolate"),
+		newUnitOption("Unit", "OnFailure", "reaper.service"),
+		newUnitOption("Unit", "Wants", "exit-watcher.service"),
+		newUnitOption("Service", "Restart", "no"),
+		newUnitOption("Service", "RootDirectory", rktpath.RelAppRootfsPath(id)),
+		newUnitOption("Service", "ExecStart", execStart),
+		newUnitOption("Service", "User", app.User),
+		newUnitOption("Service", "Group", app.Group),
 	}
 
 	for _, eh := range app.EventHandlers {
@@ -94,14 +96,28 @@ func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error
 			return fmt.Errorf("unrecognized eventHandler: %v", eh.Name)
 		}
 		exec := strings.Join(eh.Exec, " ")
-		opts = append(opts, &unit.UnitOption{"Service", typ, exec})
+		opts = append(opts, newUnitOption("Service", typ, exec))
+	}
+
+	isopts, err := isolatorsToSystemd(ra.Isolators, allowNewPrivs)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, isopts...)
+
+	enteriso, err := isolatorsToEnter(ra.Isolators, allowNewPrivs)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(rktpath.AppIsolatorsPath(c.Root, id), []byte(enteriso), 0644); err != nil {
+		return fmt.Errorf("failed to write isolators for enter: %v", err)
 	}
 
 	env := app.Environment
 	env["AC_APP_NAME"] = name
 	for ek, ev := range env {
 		ee := fmt.Sprintf(`"%s=%s"`, ek, ev)
-		opts = append(opts, &unit.UnitOption{"Service", "Environment", ee})
+		opts = append(opts, newUnitOption("Service", "Environment", ee))
 	}
 
 	saPorts := []types.Port{}
@@ -113,10 +129,10 @@ func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error
 
 	if len(saPorts) > 0 {
 		sockopts := []*unit.UnitOption{
-			&unit.UnitOption{"Unit", "Description", name + " socket-activated ports"},
-			&unit.UnitOption{"Unit", "DefaultDependencies", "false"},
-			&unit.UnitOption{"Socket", "BindIPv6Only", "both"},
-			&unit.UnitOption{"Socket", "Service", ServiceUnitName(id)},
+			newUnitOption("Unit", "Description", name+" socket-activated ports"),
+			newUnitOption("Unit", "DefaultDependencies", "false"),
+			newUnitOption("Socket", "BindIPv6Only", "both"),
+			newUnitOption("Socket", "Service", ServiceUnitName(id)),
 		}
 
 		for _, sap := range saPorts {
@@ -129,7 +145,7 @@ func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error
 			default:
 				return fmt.Errorf("unrecognized protocol: %v", sap.Protocol)
 			}
-			sockopts = append(sockopts, &unit.UnitOption{"Socket", proto, fmt.Sprintf("%v", sap.Port)})
+			sockopts = append(sockopts, newUnitOption("Socket", proto, fmt.Sprintf("%v", sap.Port)))
 		}
 
 		file, err := os.OpenFile(SocketUnitPath(c.Root, id), os.O_WRONLY|os.O_CREATE, 0644)
@@ -146,7 +162,7 @@ func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error
 			return fmt.Errorf("failed to link socket want: %v", err)
 		}
 
-		opts = append(opts, &unit.UnitOption{"Unit", "Requires", SocketUnitName(id)})
+		opts = append(opts, newUnitOption("Unit", "Requires", SocketUnitName(id)))
 	}
 
 	file, err := os.OpenFile(ServiceUnitPath(c.Root, id), os.O_WRONLY|os.O_CREATE, 0644)
@@ -167,15 +183,16 @@ func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error
 }
 
 // ContainerToSystemd creates the appropriate systemd service unit files for
-// all the constituent apps of the Container
-func (c *Container) ContainerToSystemd() error {
+// all the constituent apps of the Container. Unless allowNewPrivs is set, the
+// apps are not permitted to gain new privileges.
+func (c *Container) ContainerToSystemd(allowNewPrivs bool) error {
 	for _, am := range c.Apps {
 		a := c.Manifest.Apps.Get(am.Name)
 		if a == nil {
 			// should never happen
 			panic("app not found in container manifest")
 		}
-		if err := c.appToSystemd(am, a.ImageID); err != nil {
+		if err := c.appToSystemd(am, a, allowNewPrivs); err != nil {
 			return fmt.Errorf("failed to transform app %q into systemd service: %v", am.Name, err)
 		}
 	}
@@ -242,3 +259,9 @@ func (c *Container) ContainerToNspawnArgs() ([]string, error) {
 
 	return args, nil
 }
+
+// newUnitOption returns the option setting name to value in the given section
+// of a unit file
+func newUnitOption(section, name, value string) *unit.UnitOption {
+	return &unit.UnitOption{Section: section, Name: name, Value: value}
+}
@@ -1,17 +1,25 @@
-//+build linux
+//go:build linux
+// +build linux
 
 package main
 
 // this implements /init of stage1/nspawn+systemd
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
+	"github.com/appc/spec/schema"
 	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage1/mds"
+	"github.com/coreos/rocket/stage1/networking"
 )
 
 const (
@@ -21,6 +29,26 @@ const (
 	interpBin = "/usr/lib/ld-linux-x86-64.so.2"
 )
 
+var (
+	debug         bool
+	allowNewPrivs bool
+	privateNets   string
+	netDataDir    string
+	ports         networking.ForwardedPorts
+	mdsRegister   bool
+	mdsSocket     string
+)
+
+func init() {
+	flag.BoolVar(&debug, "debug", false, "Run in debug mode")
+	flag.BoolVar(&allowNewPrivs, "allow-new-privileges", false, "Allow apps to gain new privileges")
+	flag.StringVar(&privateNets, "net", "", "Comma-separated list of networks to attach a private network namespace to")
+	flag.StringVar(&netDataDir, "net-data-dir", "", "Directory for network plugins to keep their state in")
+	flag.Var(&ports, "port", "Port of an app to forward from the host, as name:hostPort")
+	flag.BoolVar(&mdsRegister, "mds-register", false, "Register the container with the metadata service")
+	flag.StringVar(&mdsSocket, "mds-socket", mds.DefaultSocket, "Socket the metadata service serves its registration API on")
+}
+
 // mirrorLocalZoneInfo tries to reproduce the /etc/localtime target in stage1/ to satisfy systemd-nspawn
 func mirrorLocalZoneInfo(root string) {
 	zif, err := os.Readlink("/etc/localtime")
@@ -50,8 +78,18 @@ func mirrorLocalZoneInfo(root string) {
 }
 
 func main() {
+	flag.Parse()
+
 	root := "."
-	debug := len(os.Args) > 1 && os.Args[1] == "debug"
+
+	if len(ports) > 0 && privateNets == "" {
+		fmt.Fprintln(os.Stderr, "Forwarding ports requires a private network")
+		os.Exit(6)
+	}
+	if mdsRegister && privateNets == "" {
+		fmt.Fprintln(os.Stderr, "Registering with the metadata service requires a private network")
+		os.Exit(7)
+	}
 
 	c, err := LoadContainer(root)
 	if err != nil {
@@ -61,7 +99,7 @@ func main() {
 
 	mirrorLocalZoneInfo(c.Root)
 
-	if err = c.ContainerToSystemd(); err != nil {
+	if err = c.ContainerToSystemd(allowNewPrivs); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to configure systemd: %v\n", err)
 		os.Exit(2)
 	}
@@ -92,12 +130,124 @@ func main() {
 		args = append(args, "--show-status=0")   // silence systemd initialization status output
 	}
 
+	var n *networking.Networking
+	if privateNets != "" {
+		n, err = networking.Setup(c.Root, c.Manifest.UUID.String(), strings.Split(privateNets, ","), netDataDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to setup network: %v\n", err)
+			os.Exit(6)
+		}
+		if mdsRegister {
+			if err = registerContainer(c, n); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to register with metadata service: %v\n", err)
+				teardownNetworking(c)
+				os.Exit(7)
+			}
+		}
+		if err = forwardPorts(c, n); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to forward ports: %v\n", err)
+			deregisterContainer(c)
+			teardownNetworking(c)
+			os.Exit(6)
+		}
+		// nspawn and everything it starts inherit the network namespace
+		if err = n.Enter(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to enter network namespace: %v\n", err)
+			deregisterContainer(c)
+			teardownNetworking(c)
+			os.Exit(6)
+		}
+	}
+
 	env := os.Environ()
 	env = append(env, "LD_PRELOAD="+filepath.Join(path.Stage1RootfsPath(c.Root), "fakesdboot.so"))
 	env = append(env, "LD_LIBRARY_PATH="+filepath.Join(path.Stage1RootfsPath(c.Root), "usr/lib"))
 
+	if len(ports) > 0 || mdsRegister {
+		// the forwarding rules and the registration have to be removed
+		// once the container exits, so nspawn cannot replace us
+		os.Exit(runNspawn(c, n, args, env))
+	}
+
 	if err := syscall.Exec(args[0], args, env); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to execute nspawn: %v\n", err)
 		os.Exit(5)
 	}
 }
+
+// teardownNetworking detaches the container from its networks after a failed
+// setup, reporting but otherwise ignoring errors
+func teardownNetworking(c *Container) {
+	if err := networking.Teardown(c.Root, c.Manifest.UUID.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to tear down network: %v\n", err)
+	}
+}
+
+// forwardPorts forwards the ports requested on the command line to the
+// container
+func forwardPorts(c *Container, n *networking.Networking) error {
+	var ams []*schema.ImageManifest
+	for _, am := range c.Apps {
+		ams = append(ams, am)
+	}
+	pfs, err := networking.ResolvePorts(ports, ams)
+	if err != nil {
+		return err
+	}
+	if err = n.ForwardPorts(c.Root, pfs); err != nil {
+		networking.UnforwardPorts(c.Root)
+		return err
+	}
+	return nil
+}
+
+// runNspawn runs nspawn inside the network namespace of the container and
+// removes the port forwarding and the registration with the metadata service
+// once it exits, returning its exit status
+func runNspawn(c *Container, n *networking.Networking, args []string, env []string) int {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// the terminal delivers SIGINT to nspawn directly; other signals are
+	// relayed to it
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	err := cmd.Start()
+	if lerr := n.Leave(); lerr != nil {
+		fmt.Fprintf(os.Stderr, "Failed to leave network namespace: %v\n", lerr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to execute nspawn: %v\n", err)
+		deregisterContainer(c)
+		networking.UnforwardPorts(c.Root)
+		return 5
+	}
+
+	go func() {
+		for sig := range sigs {
+			if sig != syscall.SIGINT {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	status := 0
+	if err = cmd.Wait(); err != nil {
+		status = 1
+		if ee, ok := err.(*exec.ExitError); ok {
+			if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Exited() {
+				status = ws.ExitStatus()
+			}
+		}
+	}
+
+	deregisterContainer(c)
+	if err = networking.UnforwardPorts(c.Root); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove port forwarding: %v\n", err)
+	}
+	return status
+}
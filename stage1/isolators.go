@@ -0,0 +1,179 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
+)
+
+const (
+	// Isolator names for the Linux capability sets of an app
+	capRetainSetName = "os/linux/capabilities-retain-set"
+	capRemoveSetName = "os/linux/capabilities-remove-set"
+)
+
+// linuxCapabilities lists the capabilities known to the kernel, see `man 7
+// capabilities`, in the order of their numbers
+var linuxCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+func isLinuxCapability(c string) bool {
+	return hasCapability(linuxCapabilities, c)
+}
+
+func hasCapability(caps []string, c string) bool {
+	for _, cc := range caps {
+		if c == cc {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCapabilities parses the value of a capability set isolator, a list
+// of capability names separated by whitespace or commas
+func parseCapabilities(val string) ([]string, error) {
+	var caps []string
+	for _, c := range strings.Fields(strings.Replace(val, ",", " ", -1)) {
+		c = strings.ToUpper(c)
+		if !strings.HasPrefix(c, "CAP_") {
+			c = "CAP_" + c
+		}
+		if !isLinuxCapability(c) {
+			return nil, fmt.Errorf("unknown capability %q", c)
+		}
+		caps = append(caps, c)
+	}
+	return caps, nil
+}
+
+// capSets holds the capability set isolators of an app
+type capSets struct {
+	retain, remove       []string
+	hasRetain, hasRemove bool
+}
+
+// parseCapSets parses the capability set isolators among isolators
+func parseCapSets(isolators []types.Isolator) (*capSets, error) {
+	cs := &capSets{}
+	for _, i := range isolators {
+		switch i.Name {
+		case capRetainSetName:
+			caps, err := parseCapabilities(i.Val)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s isolator: %v", i.Name, err)
+			}
+			cs.retain = append(cs.retain, caps...)
+			cs.hasRetain = true
+		case capRemoveSetName:
+			caps, err := parseCapabilities(i.Val)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s isolator: %v", i.Name, err)
+			}
+			cs.remove = append(cs.remove, caps...)
+			cs.hasRemove = true
+		}
+	}
+
+	if cs.hasRetain && cs.hasRemove {
+		return nil, fmt.Errorf("%s and %s isolators are mutually exclusive", capRetainSetName, capRemoveSetName)
+	}
+	return cs, nil
+}
+
+// isolatorsToSystemd transforms the Linux isolators of an app into systemd
+// service options. Unless allowNewPrivs is set, the app is prevented from
+// gaining new privileges through setuid binaries or file capabilities.
+func isolatorsToSystemd(isolators []types.Isolator, allowNewPrivs bool) ([]*unit.UnitOption, error) {
+	cs, err := parseCapSets(isolators)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []*unit.UnitOption
+	switch {
+	case cs.hasRetain && len(cs.retain) == 0:
+		// an empty assignment would leave the bounding set untouched
+		opts = append(opts, &unit.UnitOption{Section: "Service", Name: "CapabilityBoundingSet", Value: "~" + strings.Join(linuxCapabilities, " ")})
+	case cs.hasRetain:
+		opts = append(opts, &unit.UnitOption{Section: "Service", Name: "CapabilityBoundingSet", Value: strings.Join(cs.retain, " ")})
+	case cs.hasRemove && len(cs.remove) > 0:
+		opts = append(opts, &unit.UnitOption{Section: "Service", Name: "CapabilityBoundingSet", Value: "~" + strings.Join(cs.remove, " ")})
+	}
+
+	if !allowNewPrivs {
+		opts = append(opts, &unit.UnitOption{Section: "Service", Name: "NoNewPrivileges", Value: "true"})
+	}
+
+	return opts, nil
+}
+
+// isolatorsToEnter transforms the Linux isolators of an app into what the
+// enter helper applies to the commands run in the app by `rkt enter`: the
+// mask, in hexadecimal, of the capabilities to drop from the bounding set,
+// followed by 1 unless allowNewPrivs is set, 0 otherwise.
+func isolatorsToEnter(isolators []types.Isolator, allowNewPrivs bool) (string, error) {
+	cs, err := parseCapSets(isolators)
+	if err != nil {
+		return "", err
+	}
+
+	var drop uint64
+	for n, c := range linuxCapabilities {
+		if (cs.hasRetain && !hasCapability(cs.retain, c)) || (cs.hasRemove && hasCapability(cs.remove, c)) {
+			drop |= 1 << uint(n)
+		}
+	}
+
+	noNewPrivs := 1
+	if allowNewPrivs {
+		noNewPrivs = 0
+	}
+	return fmt.Sprintf("%x %d\n", drop, noNewPrivs), nil
+}
@@ -0,0 +1,140 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
+)
+
+func TestIsolatorsToSystemd(t *testing.T) {
+	noNewPrivs := &unit.UnitOption{Section: "Service", Name: "NoNewPrivileges", Value: "true"}
+	bounding := func(v string) *unit.UnitOption {
+		return &unit.UnitOption{Section: "Service", Name: "CapabilityBoundingSet", Value: v}
+	}
+	retain := func(v string) types.Isolator {
+		return types.Isolator{Name: capRetainSetName, Val: v}
+	}
+	remove := func(v string) types.Isolator {
+		return types.Isolator{Name: capRemoveSetName, Val: v}
+	}
+
+	tests := []struct {
+		isolators     []types.Isolator
+		allowNewPrivs bool
+		want          []*unit.UnitOption
+		err           bool
+	}{
+		// no isolators
+		{nil, false, []*unit.UnitOption{noNewPrivs}, false},
+		{nil, true, nil, false},
+		// a retain set
+		{
+			[]types.Isolator{retain("CAP_NET_BIND_SERVICE, CAP_CHOWN")},
+			false,
+			[]*unit.UnitOption{bounding("CAP_NET_BIND_SERVICE CAP_CHOWN"), noNewPrivs},
+			false,
+		},
+		// a remove set
+		{
+			[]types.Isolator{remove("CAP_SYS_ADMIN CAP_NET_ADMIN")},
+			true,
+			[]*unit.UnitOption{bounding("~CAP_SYS_ADMIN CAP_NET_ADMIN")},
+			false,
+		},
+		// an empty remove set changes nothing
+		{[]types.Isolator{remove("")}, true, nil, false},
+		// an empty retain set drops every capability
+		{
+			[]types.Isolator{retain("")},
+			false,
+			[]*unit.UnitOption{bounding("~" + strings.Join(linuxCapabilities, " ")), noNewPrivs},
+			false,
+		},
+		// names without prefix, in any case
+		{
+			[]types.Isolator{retain("net_bind_service,Kill")},
+			true,
+			[]*unit.UnitOption{bounding("CAP_NET_BIND_SERVICE CAP_KILL")},
+			false,
+		},
+		// capabilities of recent kernels
+		{
+			[]types.Isolator{remove("bpf perfmon checkpoint_restore")},
+			true,
+			[]*unit.UnitOption{bounding("~CAP_BPF CAP_PERFMON CAP_CHECKPOINT_RESTORE")},
+			false,
+		},
+		// unknown capabilities
+		{[]types.Isolator{retain("CAP_FLY")}, false, nil, true},
+		{[]types.Isolator{remove("fly")}, false, nil, true},
+		// both sets
+		{[]types.Isolator{retain("CAP_CHOWN"), remove("CAP_KILL")}, false, nil, true},
+		{[]types.Isolator{retain(""), remove("")}, false, nil, true},
+	}
+	for i, tt := range tests {
+		opts, err := isolatorsToSystemd(tt.isolators, tt.allowNewPrivs)
+		if tt.err {
+			if err == nil {
+				t.Errorf("#%d: expected an error, got options %v", i, opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts, tt.want) {
+			t.Errorf("#%d: got options %v, wanted %v", i, opts, tt.want)
+		}
+	}
+}
+
+func TestIsolatorsToEnter(t *testing.T) {
+	retain := func(v string) types.Isolator {
+		return types.Isolator{Name: capRetainSetName, Val: v}
+	}
+	remove := func(v string) types.Isolator {
+		return types.Isolator{Name: capRemoveSetName, Val: v}
+	}
+
+	tests := []struct {
+		isolators     []types.Isolator
+		allowNewPrivs bool
+		want          string
+		err           bool
+	}{
+		// no isolators
+		{nil, false, "0 1\n", false},
+		{nil, true, "0 0\n", false},
+		// a remove set: CAP_SYS_ADMIN is 21, CAP_CHECKPOINT_RESTORE 40
+		{[]types.Isolator{remove("CAP_SYS_ADMIN checkpoint_restore")}, true, "10000200000 0\n", false},
+		// a retain set: all but CAP_CHOWN (0) and CAP_KILL (5)
+		{[]types.Isolator{retain("CAP_CHOWN, CAP_KILL")}, false, "1ffffffffde 1\n", false},
+		// an empty retain set drops every capability
+		{[]types.Isolator{retain("")}, false, "1ffffffffff 1\n", false},
+		// both sets
+		{[]types.Isolator{retain("CAP_CHOWN"), remove("CAP_KILL")}, false, "", true},
+	}
+	for i, tt := range tests {
+		got, err := isolatorsToEnter(tt.isolators, tt.allowNewPrivs)
+		if tt.err {
+			if err == nil {
+				t.Errorf("#%d: expected an error, got %q", i, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("#%d: got %q, wanted %q", i, got, tt.want)
+		}
+	}
+}
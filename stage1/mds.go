@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage1/mds"
+	"github.com/coreos/rocket/stage1/networking"
+)
+
+// registerContainer registers the container and its apps with the metadata
+// service, so that the apps can query their metadata. The container is
+// identified by its address on its first network. A partial registration is
+// undone.
+func registerContainer(c *Container, n *networking.Networking) error {
+	if len(n.Nets) == 0 {
+		return fmt.Errorf("registering with the metadata service requires a private network")
+	}
+	ni := n.Nets[0]
+	if ni.HostIf == "" {
+		return fmt.Errorf("network %q has no host interface for the metadata service to guard", ni.Name)
+	}
+
+	cmf, err := ioutil.ReadFile(path.ContainerManifestPath(c.Root))
+	if err != nil {
+		return fmt.Errorf("failed reading container runtime manifest: %v", err)
+	}
+
+	// recorded first, so that the registration can be undone whatever
+	// happens next
+	if err := mds.SaveSocket(c.Root, mdsSocket); err != nil {
+		return err
+	}
+
+	v := url.Values{}
+	v.Set("container_ip", ni.IP.String())
+	v.Set("container_brport", ni.HostIf)
+	if err := mds.Request(mdsSocket, "POST", "/containers/?"+v.Encode(), cmf); err != nil {
+		deregisterContainer(c)
+		return fmt.Errorf("failed registering container: %v", err)
+	}
+
+	for _, ra := range c.Manifest.Apps {
+		amf, err := ioutil.ReadFile(path.ImageManifestPath(c.Root, ra.ImageID))
+		if err != nil {
+			deregisterContainer(c)
+			return fmt.Errorf("failed reading app manifest: %v", err)
+		}
+		p := fmt.Sprintf("/containers/%s/%s", c.Manifest.UUID, ra.Name)
+		if err := mds.Request(mdsSocket, "PUT", p, amf); err != nil {
+			deregisterContainer(c)
+			return fmt.Errorf("failed registering app %q: %v", ra.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// deregisterContainer removes the container from the metadata service it is
+// registered with, if any, reporting but otherwise ignoring errors
+func deregisterContainer(c *Container) {
+	if err := mds.Deregister(c.Root, c.Manifest.UUID); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to deregister from metadata service: %v\n", err)
+	}
+}
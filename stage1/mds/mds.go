@@ -0,0 +1,98 @@
+// Package mds is a client of the registration API metadatasvc serves on a
+// Unix socket. The socket a container is registered through is recorded in
+// the container directory, so that it can be deregistered from the host once
+// it is gone.
+package mds
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+const (
+	// DefaultSocket is the socket metadatasvc serves its registration API
+	// on by default
+	DefaultSocket = "/run/rkt/metadata-svc.sock"
+
+	timeout = 10 * time.Second
+)
+
+// SaveSocket records socket as the one the container rooted at root is
+// registered through, before registering it
+func SaveSocket(root string, socket string) error {
+	if err := ioutil.WriteFile(rktpath.MDSSocketPath(root), []byte(socket+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed recording metadata service socket: %v", err)
+	}
+	return nil
+}
+
+// Request sends a request for the registration API path to the metadata
+// service serving it on socket, failing unless it succeeds
+func Request(socket, method, path string, body []byte) error {
+	status, msg, err := request(socket, method, path, body)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("metadata service returned %d %s: %s", status, http.StatusText(status), msg)
+	}
+	return nil
+}
+
+// Deregister removes the container rooted at root, with the given UUID, from
+// the metadata service it was registered with, if any. Containers the
+// service has already forgotten are fine.
+func Deregister(root string, uid types.UUID) error {
+	b, err := ioutil.ReadFile(rktpath.MDSSocketPath(root))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed reading metadata service socket: %v", err)
+	}
+	socket := strings.TrimSpace(string(b))
+
+	status, msg, err := request(socket, "DELETE", "/containers/"+uid.String(), nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusNotFound {
+		return fmt.Errorf("failed deregistering container: metadata service returned %d %s: %s", status, http.StatusText(status), msg)
+	}
+	return os.Remove(rktpath.MDSSocketPath(root))
+}
+
+// request sends a request to the metadata service serving on socket,
+// returning the status and body of the response
+func request(socket, method, path string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, "http://metadatasvc"+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(string, string) (net.Conn, error) {
+				return net.DialTimeout("unix", socket, timeout)
+			},
+		},
+		Timeout: timeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("metadata service unreachable at %s (is metadatasvc running?): %v", socket, err)
+	}
+	defer resp.Body.Close()
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, bytes.TrimSpace(msg), nil
+}
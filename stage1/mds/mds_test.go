@@ -0,0 +1,132 @@
+package mds
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+const testUUID = "6733c3a4-4d1f-4b6e-8d3c-5a6e0b5c2f10"
+
+// fakeService serves the registration API on a socket in dir, replying with
+// status and recording the requests
+type fakeService struct {
+	socket string
+	l      net.Listener
+
+	mu     sync.Mutex
+	status int
+	reqs   []string
+}
+
+func newFakeService(t *testing.T, dir string) *fakeService {
+	s := &fakeService{socket: filepath.Join(dir, "mds.sock"), status: http.StatusOK}
+	l, err := net.Listen("unix", s.socket)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	s.l = l
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.reqs = append(s.reqs, r.Method+" "+r.URL.Path)
+		w.WriteHeader(s.status)
+	}))
+	return s
+}
+
+func (s *fakeService) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *fakeService) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reqs
+}
+
+func TestDeregister(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mds")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s := newFakeService(t, dir)
+	defer s.l.Close()
+	uid, err := types.NewUUID(testUUID)
+	if err != nil {
+		t.Fatalf("error parsing UUID: %v", err)
+	}
+
+	// never registered
+	if err := Deregister(dir, *uid); err != nil {
+		t.Fatalf("error deregistering unregistered container: %v", err)
+	}
+	if reqs := s.requests(); len(reqs) != 0 {
+		t.Fatalf("unexpected requests: %v", reqs)
+	}
+
+	if err := SaveSocket(dir, s.socket); err != nil {
+		t.Fatalf("error saving socket: %v", err)
+	}
+	if err := Request(s.socket, "POST", "/containers/", nil); err != nil {
+		t.Fatalf("error registering: %v", err)
+	}
+
+	// failures keep the record, for a retry
+	s.setStatus(http.StatusInternalServerError)
+	if err := Deregister(dir, *uid); err == nil {
+		t.Fatalf("expected deregistration to fail")
+	}
+	if _, err := os.Stat(rktpath.MDSSocketPath(dir)); err != nil {
+		t.Fatalf("socket record removed: %v", err)
+	}
+
+	// containers already forgotten by the service are fine
+	s.setStatus(http.StatusNotFound)
+	if err := Deregister(dir, *uid); err != nil {
+		t.Fatalf("error deregistering: %v", err)
+	}
+	if _, err := os.Stat(rktpath.MDSSocketPath(dir)); !os.IsNotExist(err) {
+		t.Errorf("socket record left behind: %v", err)
+	}
+
+	want := []string{"POST /containers/", "DELETE /containers/" + testUUID, "DELETE /containers/" + testUUID}
+	reqs := s.requests()
+	if len(reqs) != len(want) {
+		t.Fatalf("got requests %v, wanted %v", reqs, want)
+	}
+	for i := range want {
+		if reqs[i] != want[i] {
+			t.Errorf("got requests %v, wanted %v", reqs, want)
+			break
+		}
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mds")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := Request(filepath.Join(dir, "missing.sock"), "POST", "/containers/", nil); err == nil {
+		t.Errorf("expected request to a missing service to fail")
+	}
+	s := newFakeService(t, dir)
+	defer s.l.Close()
+	s.setStatus(http.StatusBadRequest)
+	if err := Request(s.socket, "POST", "/containers/", nil); err == nil {
+		t.Errorf("expected rejected request to fail")
+	}
+}
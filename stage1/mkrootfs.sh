@@ -185,6 +185,7 @@ BINDIR="${WORK}/bins"
 USR="rootfs/usr"
 FILELIST="filelist.txt"
 OUTPUT=${OUTPUT:="../stage0/stage1_rootfs/bin.go"}
+PLUGINS=${PLUGINS:="../bin/plugins/net"}
 
 # cache pxe image
 cache_url "${CACHED_IMG}" "${IMG_URL}" "${GPG_KEY}" "${GPG_LONG_ID}"
@@ -376,17 +377,23 @@ EOF
 install -d -m 0755 "${ROOTDIR}/usr/lib/systemd/system/default.target.wants"
 install -d -m 0755 "${ROOTDIR}/usr/lib/systemd/system/sockets.target.wants"
 
-# simple reaper script for collecting the exit statuses of the apps
+# simple reaper script for collecting the exit statuses of the apps, in files
+# named after their services, written atomically for the host to read
 cat > "${ROOTDIR}/reaper.sh" <<-'EOF'
 #!/usr/bin/bash
 shopt -s nullglob
 
 SYSCTL=/usr/bin/systemctl
-
-cd /opt/stage2
-for app in *; do 
-        status=$(${SYSCTL} show --property ExecMainStatus "${app}.service")
-        echo "${status#*=}" > "/rkt/status/$app"
+STATUSDIR=/rkt/status
+
+cd /usr/lib/systemd/system/default.target.wants
+for unit in *.service; do
+        app="${unit%.service}"
+        started=$(${SYSCTL} show --property ExecMainStartTimestampMonotonic "${unit}")
+        [ "${started#*=}" != "0" ] || continue
+        status=$(${SYSCTL} show --property ExecMainStatus "${unit}")
+        echo "${status#*=}" > "${STATUSDIR}/.${app}"
+        mv "${STATUSDIR}/.${app}" "${STATUSDIR}/${app}"
 done
 
 ${SYSCTL} halt --force
@@ -489,15 +496,144 @@ long syscall(long number, ...)
 }
 EOF
 
+# helper for `rkt enter`, run from the host through the stage1 interpreter
+gcc -x c -pipe -Wall -o ${ROOTDIR}/enter - <<'EOF'
+#define _GNU_SOURCE
+#include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
+#include <signal.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/prctl.h>
+#include <sys/types.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+#ifndef PR_SET_NO_NEW_PRIVS
+#define PR_SET_NO_NEW_PRIVS	38
+#endif
+
+/* usage: enter PID APPROOT ISOLATORS CMD [ARGS...]
+ * joins the namespaces of the container whose init is PID, chroots into the
+ * app's rootfs APPROOT (relative to the stage1 rootfs) and runs CMD there
+ * under the app's isolators, recorded by stage1 in ISOLATORS (within the
+ * stage1 rootfs), exiting with its status
+ */
+
+#define exit_if(_cond, _fmt, _args...)					\
+	if(_cond) {							\
+		fprintf(stderr, "enter: " _fmt ": %s\n", ##_args, strerror(errno)); \
+		exit(1);						\
+	}
+
+/* mnt comes last, /proc/PID must still be reachable before */
+static const char *nss[] = { "ipc", "uts", "net", "pid", "mnt" };
+#define NNSS	(sizeof(nss) / sizeof(nss[0]))
+
+/* applies the isolators recorded in path: the mask of the capabilities to
+ * drop from the bounding set, and whether to forbid gaining new privileges,
+ * as systemd does for the app
+ */
+static void isolate(const char *path)
+{
+	FILE			*f;
+	unsigned long long	drop;
+	int			nnp, cap;
+
+	f = fopen(path, "r");
+	exit_if(f == NULL, "unable to open %s", path);
+	if(fscanf(f, "%llx %i", &drop, &nnp) != 2) {
+		fprintf(stderr, "enter: malformed %s\n", path);
+		exit(1);
+	}
+	fclose(f);
+
+	for(cap = 0; cap < 64; cap++) {
+		if(!(drop & (1ULL << cap)))
+			continue;
+		/* capabilities unknown to the running kernel are not there to drop */
+		exit_if(prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) == -1 && errno != EINVAL,
+			"unable to drop capability %i", cap);
+	}
+	if(nnp)
+		exit_if(prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) == -1,
+			"unable to forbid new privileges");
+}
+
+int main(int argc, char *argv[])
+{
+	int	fds[NNSS];
+	pid_t	pid, child;
+	int	i, status;
+	char	path[64];
+
+	if(argc < 5) {
+		fprintf(stderr, "usage: %s PID APPROOT ISOLATORS CMD [ARGS...]\n", argv[0]);
+		exit(1);
+	}
+	pid = atoi(argv[1]);
+
+	/* only meant for the stage1 interpreter, not for the app */
+	unsetenv("LD_LIBRARY_PATH");
+
+	for(i = 0; i < NNSS; i++) {
+		snprintf(path, sizeof(path), "/proc/%i/ns/%s", pid, nss[i]);
+		fds[i] = open(path, O_RDONLY|O_CLOEXEC);
+		exit_if(fds[i] == -1, "unable to open %s", path);
+	}
+	for(i = 0; i < NNSS; i++) {
+		exit_if(setns(fds[i], 0) == -1, "unable to join %s namespace", nss[i]);
+		close(fds[i]);
+	}
+
+	/* ISOLATORS is out of reach once in the app's rootfs. Only the command
+	 * is bound by them, the effective capabilities of this process remain.
+	 */
+	isolate(argv[3]);
+
+	exit_if(chroot(argv[2]) == -1, "unable to chroot to %s", argv[2]);
+	exit_if(chdir("/") == -1, "unable to chdir to /");
+
+	/* joining the pid namespace only applies to children */
+	child = fork();
+	exit_if(child == -1, "unable to fork");
+	if(child == 0) {
+		execvp(argv[4], &argv[4]);
+		fprintf(stderr, "enter: unable to execute %s: %s\n", argv[4], strerror(errno));
+		exit(127);
+	}
+
+	/* the terminal signals the command, we only wait for it */
+	signal(SIGINT, SIG_IGN);
+	signal(SIGQUIT, SIG_IGN);
+
+	exit_if(waitpid(child, &status, 0) == -1, "unable to wait for %s", argv[4]);
+	if(WIFSIGNALED(status))
+		return 128 + WTERMSIG(status);
+	return WEXITSTATUS(status);
+}
+EOF
+
 install -d "${ROOTDIR}/etc"
 echo "rocket" > "${ROOTDIR}/etc/os-release"
 
 # parent dir for the stage2 bind mounts
 install -d "${ROOTDIR}/opt/stage2"
 
-# dir for result code files
+# dir for result code files, see pkg/status
 install -d "${ROOTDIR}/rkt/status"
 
+# the isolators of the apps, see path.AppIsolatorsPath
+install -d "${ROOTDIR}/rkt/isolators"
+
+# network plugins, see networking.PluginDir
+install -d "${ROOTDIR}/usr/lib/rkt/plugins/net"
+for PLUGIN in "${PLUGINS}"/*; do
+	install -m 0755 "${PLUGIN}" "${ROOTDIR}/usr/lib/rkt/plugins/net/"
+done
+
 # fin
 mkdir "${BINDIR}"
 tar cf "${BINDIR}/s1rootfs.tar" -C "${ROOTDIR}" .
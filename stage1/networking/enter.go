@@ -0,0 +1,65 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+package networking
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// setns(2) is missing from the syscall package
+const sysSetns = 308
+
+// Enter switches the calling thread into the container's network namespace,
+// so that a subsequently exec()ed or started process runs inside it.
+// The calling goroutine stays locked to its thread.
+func (n *Networking) Enter() error {
+	runtime.LockOSThread()
+
+	hostNS, err := os.Open("/proc/self/ns/net")
+	if err != nil {
+		return fmt.Errorf("error opening host network namespace: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(netnsDir, n.NetNS))
+	if err != nil {
+		hostNS.Close()
+		return fmt.Errorf("error opening network namespace: %v", err)
+	}
+	defer f.Close()
+
+	if err := setns(f); err != nil {
+		hostNS.Close()
+		return fmt.Errorf("error entering network namespace: %v", err)
+	}
+	n.hostNS = hostNS
+	return nil
+}
+
+// Leave switches the calling thread back into the host's network namespace
+// after Enter
+func (n *Networking) Leave() error {
+	if n.hostNS == nil {
+		return nil
+	}
+	defer runtime.UnlockOSThread()
+
+	err := setns(n.hostNS)
+	n.hostNS.Close()
+	n.hostNS = nil
+	if err != nil {
+		return fmt.Errorf("error leaving network namespace: %v", err)
+	}
+	return nil
+}
+
+func setns(f *os.File) error {
+	if _, _, errno := syscall.RawSyscall(sysSetns, f.Fd(), syscall.CLONE_NEWNET, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+// Package networking implements the private networking of containers: every
+// container gets its own network namespace, attached to one or more networks.
+// Networks are described by configuration files in ConfDir and implemented by
+// plugins in PluginDir of the stage1 rootfs, see package plugin.
+package networking
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage1/networking/plugin"
+)
+
+const (
+	// ConfDir holds the network configuration files
+	ConfDir = "/etc/rkt/net.d"
+	// PluginDir holds the network plugins, relative to the stage1 rootfs
+	PluginDir = "/usr/lib/rkt/plugins/net"
+
+	// DefaultNetName is the network used when none is specified
+	DefaultNetName = "default"
+
+	// where `ip netns` keeps the named network namespaces
+	netnsDir = "/var/run/netns"
+)
+
+// defaultNetConf is used for DefaultNetName unless ConfDir overrides it
+var defaultNetConf = []byte(`{
+	"name": "default",
+	"type": "bridge",
+	"bridge": "rkt0",
+	"ipMasq": true,
+	"ipam": {
+		"type": "host-local",
+		"subnet": "172.16.28.0/24"
+	}
+}`)
+
+// NetInfo describes the attachment of a container to a network, as recorded
+// in the container directory
+type NetInfo struct {
+	Name      string          `json:"name"`            // name of the network
+	Type      string          `json:"type"`            // plugin implementing the network
+	NetNS     string          `json:"netns"`           // name of the container's network namespace
+	IfName    string          `json:"ifName"`          // interface of the container on the network
+	HostIf    string          `json:"hostIf"`          // host side interface, if any
+	IP        net.IP          `json:"ip"`              // address of the container
+	PrefixLen int             `json:"prefixLen"`       // length of the subnet mask
+	Gateway   net.IP          `json:"gateway"`         // gateway of the network
+	DataDir   string          `json:"dataDir"`         // directory plugins keep their state in
+	Conf      json.RawMessage `json:"conf"`            // the network configuration
+	Ports     []PortFwd       `json:"ports,omitempty"` // ports of the host forwarded to the container
+}
+
+// Networking describes the networks of a container
+type Networking struct {
+	NetNS string // name of the container's network namespace
+	Nets  []*NetInfo
+
+	hostNS *os.File // the host's network namespace, while entered
+}
+
+// LoadNetConfs returns the network configurations found in dir, indexed by
+// network name
+func LoadNetConfs(dir string) (map[string]json.RawMessage, error) {
+	confs := make(map[string]json.RawMessage)
+	ls, err := ioutil.ReadDir(dir)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+	default:
+		return nil, err
+	}
+
+	for _, fi := range ls {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".conf" {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		nc := &plugin.NetConf{}
+		if err := json.Unmarshal(buf, nc); err != nil {
+			return nil, fmt.Errorf("error parsing %q: %v", fi.Name(), err)
+		}
+		if nc.Name == "" || nc.Type == "" {
+			return nil, fmt.Errorf("%q: network name and type are required", fi.Name())
+		}
+		if _, ok := confs[nc.Name]; ok {
+			return nil, fmt.Errorf("%q: network %q is defined more than once", fi.Name(), nc.Name)
+		}
+		confs[nc.Name] = buf
+	}
+
+	if _, ok := confs[DefaultNetName]; !ok {
+		confs[DefaultNetName] = defaultNetConf
+	}
+	return confs, nil
+}
+
+// Setup creates a network namespace for the container rooted at root and
+// attaches it to the named networks, in order. The container's id is used to
+// name the namespace and to account for its addresses; plugins keep their
+// state in dataDir.
+func Setup(root string, id string, netNames []string, dataDir string) (*Networking, error) {
+	confs, err := LoadNetConfs(ConfDir)
+	if err != nil {
+		return nil, fmt.Errorf("error loading network configurations: %v", err)
+	}
+
+	n := &Networking{NetNS: netNSName(id)}
+	seen := make(map[string]bool)
+	for i, name := range netNames {
+		if seen[name] {
+			return nil, fmt.Errorf("network %q specified more than once", name)
+		}
+		seen[name] = true
+		conf, ok := confs[name]
+		if !ok {
+			return nil, fmt.Errorf("no configuration found for network %q", name)
+		}
+		nc := &plugin.NetConf{}
+		if err := json.Unmarshal(conf, nc); err != nil {
+			return nil, fmt.Errorf("error parsing configuration of network %q: %v", name, err)
+		}
+		n.Nets = append(n.Nets, &NetInfo{
+			Name:    name,
+			Type:    nc.Type,
+			NetNS:   n.NetNS,
+			IfName:  fmt.Sprintf("eth%d", i),
+			DataDir: dataDir,
+			Conf:    conf,
+		})
+	}
+
+	if err := plugin.IP("netns", "add", n.NetNS); err != nil {
+		return nil, fmt.Errorf("error creating network namespace: %v", err)
+	}
+	if err := plugin.IPNetNS(n.NetNS, "link", "set", "lo", "up"); err != nil {
+		Teardown(root, id)
+		return nil, fmt.Errorf("error configuring loopback: %v", err)
+	}
+
+	for _, ni := range n.Nets {
+		// record the attachment first so a partial setup can be torn down
+		if err := saveNetInfo(root, ni); err != nil {
+			Teardown(root, id)
+			return nil, err
+		}
+
+		res, err := plugin.Exec(ni.Type, ni.request(root, plugin.CmdAdd, id))
+		if err != nil {
+			Teardown(root, id)
+			return nil, fmt.Errorf("error adding container to network %q: %v", ni.Name, err)
+		}
+		ni.HostIf = res.HostIf
+		ni.IP = res.IP
+		ni.PrefixLen = res.PrefixLen
+		ni.Gateway = res.Gateway
+
+		if err := saveNetInfo(root, ni); err != nil {
+			Teardown(root, id)
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// Teardown detaches the container rooted at root from every network it has
+// been attached to, and removes its network namespace.
+func Teardown(root string, id string) error {
+	nis, err := LoadNetInfo(root)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, ni := range nis {
+		if err := unforwardPorts(ni); err != nil {
+			errs = append(errs, fmt.Sprintf("network %q: %v", ni.Name, err))
+			continue
+		}
+		if _, err := plugin.Exec(ni.Type, ni.request(root, plugin.CmdDel, id)); err != nil {
+			errs = append(errs, fmt.Sprintf("network %q: %v", ni.Name, err))
+			continue
+		}
+		os.Remove(netInfoPath(root, ni.Name))
+	}
+
+	if _, err := os.Stat(filepath.Join(netnsDir, netNSName(id))); err == nil {
+		if err := plugin.IP("netns", "del", netNSName(id)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error tearing down networking: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// LoadNetInfo returns the networks the container rooted at root is attached to
+func LoadNetInfo(root string) ([]*NetInfo, error) {
+	ls, err := ioutil.ReadDir(rktpath.NetInfoDir(root))
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	var nis []*NetInfo
+	for _, fi := range ls {
+		if filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(rktpath.NetInfoDir(root), fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ni := &NetInfo{}
+		if err := json.Unmarshal(buf, ni); err != nil {
+			return nil, fmt.Errorf("error unmarshalling network info %q: %v", fi.Name(), err)
+		}
+		nis = append(nis, ni)
+	}
+	return nis, nil
+}
+
+// request builds the plugin request for the container rooted at root, whose
+// stage1 rootfs provides the plugins
+func (ni *NetInfo) request(root string, cmd string, id string) *plugin.Request {
+	return &plugin.Request{
+		Command:     cmd,
+		ContainerID: id,
+		NetNS:       ni.NetNS,
+		IfName:      ni.IfName,
+		DataDir:     ni.DataDir,
+		PluginDir:   filepath.Join(rktpath.Stage1RootfsPath(root), PluginDir),
+		Net:         ni.Conf,
+	}
+}
+
+func saveNetInfo(root string, ni *NetInfo) error {
+	if err := os.MkdirAll(rktpath.NetInfoDir(root), 0755); err != nil {
+		return fmt.Errorf("error creating network info directory: %v", err)
+	}
+	buf, err := json.Marshal(ni)
+	if err != nil {
+		return fmt.Errorf("error marshalling network info: %v", err)
+	}
+	return ioutil.WriteFile(netInfoPath(root, ni.Name), buf, 0644)
+}
+
+func netInfoPath(root, name string) string {
+	return filepath.Join(rktpath.NetInfoDir(root), name+".json")
+}
+
+func netNSName(id string) string {
+	return "rkt-" + id
+}
@@ -0,0 +1,60 @@
+package networking
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadNetConfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-net")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a missing directory still provides the default network
+	confs, err := LoadNetConfs(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := confs[DefaultNetName]; !ok || len(confs) != 1 {
+		t.Errorf("expected only the default network, got %v", confs)
+	}
+
+	files := map[string]string{
+		"10-default.conf": `{"name": "default", "type": "macvlan", "master": "eth0"}`,
+		"20-backend.conf": `{"name": "backend", "type": "bridge"}`,
+		"README":          `not a configuration`,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("error writing %q: %v", name, err)
+		}
+	}
+
+	confs, err = LoadNetConfs(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(confs) != 2 {
+		t.Errorf("unexpected number of networks: %d, wanted 2", len(confs))
+	}
+	if string(confs[DefaultNetName]) != files["10-default.conf"] {
+		t.Errorf("default network not overridden: %s", confs[DefaultNetName])
+	}
+
+	for _, contents := range []string{
+		`{"name": "backend", "type": "macvlan"}`,
+		`{"name": "notype"}`,
+		`not json`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "30-bad.conf"), []byte(contents), 0644); err != nil {
+			t.Fatalf("error writing configuration: %v", err)
+		}
+		if _, err := LoadNetConfs(dir); err == nil {
+			t.Errorf("expected error loading configuration %s", contents)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package plugin implements the contract between rkt and its network plugins.
+//
+// A network plugin is an executable named after the type of network it
+// implements. It is invoked with a JSON-encoded Request on stdin and, when
+// adding a container to a network, replies with a JSON-encoded Result on
+// stdout. Failures are reported with a non-zero exit status and a message on
+// stderr.
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CmdAdd attaches a container to a network
+	CmdAdd = "ADD"
+	// CmdDel detaches a container from a network
+	CmdDel = "DEL"
+)
+
+// Request is passed to a plugin on stdin
+type Request struct {
+	Command     string          `json:"command"`     // CmdAdd or CmdDel
+	ContainerID string          `json:"containerID"` // UUID of the container
+	NetNS       string          `json:"netns"`       // name of the container's network namespace, see ip-netns(8)
+	IfName      string          `json:"ifName"`      // name of the interface to create inside the namespace
+	DataDir     string          `json:"dataDir"`     // directory plugins may keep state in
+	PluginDir   string          `json:"pluginDir"`   // directory of the plugins, for delegating to IPAM plugins
+	Net         json.RawMessage `json:"net"`         // the network configuration
+}
+
+// Result is returned by a plugin on stdout after adding a container to a
+// network
+type Result struct {
+	IP        net.IP `json:"ip,omitempty"`        // address of the container
+	PrefixLen int    `json:"prefixLen,omitempty"` // length of the subnet mask
+	Gateway   net.IP `json:"gateway,omitempty"`   // gateway of the network
+	HostIf    string `json:"hostIf,omitempty"`    // interface on the host side, e.g. the host end of a veth pair
+}
+
+// NetConf holds the fields common to every network configuration
+type NetConf struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	IPAM struct {
+		Type string `json:"type"`
+	} `json:"ipam"`
+}
+
+// Exec runs the plugin of the given type, found in req.PluginDir, with the
+// given request
+func Exec(typ string, req *Request) (*Result, error) {
+	if typ == "" || strings.ContainsRune(typ, filepath.Separator) {
+		return nil, fmt.Errorf("invalid plugin type %q", typ)
+	}
+
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling plugin request: %v", err)
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(filepath.Join(req.PluginDir, typ))
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("plugin %q failed: %v: %s", typ, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	res := &Result{}
+	if req.Command == CmdAdd {
+		if err := json.Unmarshal(stdout.Bytes(), res); err != nil {
+			return nil, fmt.Errorf("error unmarshalling result of plugin %q: %v", typ, err)
+		}
+	}
+	return res, nil
+}
+
+// ExecIPAM delegates the given request to the IPAM plugin named in the
+// network configuration
+func ExecIPAM(req *Request) (*Result, error) {
+	conf := &NetConf{}
+	if err := json.Unmarshal(req.Net, conf); err != nil {
+		return nil, fmt.Errorf("error parsing network configuration: %v", err)
+	}
+	if conf.IPAM.Type == "" {
+		return nil, fmt.Errorf("network %q has no IPAM plugin configured", conf.Name)
+	}
+	return Exec(conf.IPAM.Type, req)
+}
+
+// Serve implements the main function of a plugin: it decodes the request on
+// stdin and dispatches it to add or del. It does not return.
+func Serve(add func(*Request) (*Result, error), del func(*Request) error) {
+	req := &Request{}
+	if err := json.NewDecoder(os.Stdin).Decode(req); err != nil {
+		fatalf("error decoding request: %v", err)
+	}
+
+	switch req.Command {
+	case CmdAdd:
+		res, err := add(req)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(res); err != nil {
+			fatalf("error encoding result: %v", err)
+		}
+	case CmdDel:
+		if err := del(req); err != nil {
+			fatalf("%v", err)
+		}
+	default:
+		fatalf("unknown command %q", req.Command)
+	}
+	os.Exit(0)
+}
+
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
+
+// IP runs the ip(8) command with the given arguments
+func IP(args ...string) error {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// IPNetNS runs the ip(8) command with the given arguments inside the named
+// network namespace
+func IPNetNS(netns string, args ...string) error {
+	return IP(append([]string{"netns", "exec", netns, "ip"}, args...)...)
+}
+
+// ConfigureIface assigns the address from res to the named interface in the
+// network namespace and brings it up. A default route through the gateway is
+// added unless the namespace already has one.
+func ConfigureIface(netns, ifName string, res *Result) error {
+	addr := fmt.Sprintf("%v/%d", res.IP, res.PrefixLen)
+	if err := IPNetNS(netns, "addr", "add", addr, "dev", ifName); err != nil {
+		return err
+	}
+	if err := IPNetNS(netns, "link", "set", ifName, "up"); err != nil {
+		return err
+	}
+	if res.Gateway == nil {
+		return nil
+	}
+	out, err := exec.Command("ip", "netns", "exec", netns, "ip", "route", "show", "default").Output()
+	if err != nil {
+		return fmt.Errorf("error listing routes: %v", err)
+	}
+	if len(bytes.TrimSpace(out)) > 0 {
+		return nil
+	}
+	return IPNetNS(netns, "route", "add", "default", "via", res.Gateway.String(), "dev", ifName)
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-plugin")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	plugins := map[string]string{
+		"echo": "#!/bin/sh\ncat > " + filepath.Join(dir, "request") + "\necho '{\"ip\": \"10.1.2.3\", \"prefixLen\": 24}'\n",
+		"fail": "#!/bin/sh\necho 'no bridge' >&2\nexit 1\n",
+	}
+	for name, script := range plugins {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatalf("error writing plugin: %v", err)
+		}
+	}
+
+	req := &Request{
+		Command:     CmdAdd,
+		ContainerID: "c1",
+		NetNS:       "rkt-c1",
+		IfName:      "eth0",
+		PluginDir:   dir,
+		Net:         []byte(`{"name": "test", "type": "echo"}`),
+	}
+	res, err := Exec("echo", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.IP.Equal(net.ParseIP("10.1.2.3")) || res.PrefixLen != 24 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "request")); err != nil {
+		t.Errorf("plugin did not receive the request: %v", err)
+	}
+
+	if _, err := Exec("fail", req); err == nil {
+		t.Errorf("expected error from failing plugin")
+	}
+	for _, typ := range []string{"", "../echo", "missing"} {
+		if _, err := Exec(typ, req); err == nil {
+			t.Errorf("expected error executing plugin %q", typ)
+		}
+	}
+}
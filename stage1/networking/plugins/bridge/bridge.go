@@ -0,0 +1,138 @@
+// bridge is a network plugin connecting containers to a bridge on the host
+// through a veth pair.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/rocket/stage1/networking/plugin"
+)
+
+const defaultBridge = "rkt0"
+
+type netConf struct {
+	plugin.NetConf
+	Bridge string `json:"bridge"`
+	IPMasq bool   `json:"ipMasq"`
+}
+
+func loadNetConf(req *plugin.Request) (*netConf, error) {
+	conf := &netConf{Bridge: defaultBridge}
+	if err := json.Unmarshal(req.Net, conf); err != nil {
+		return nil, fmt.Errorf("error parsing network configuration: %v", err)
+	}
+	return conf, nil
+}
+
+// ifSuffix returns a suffix identifying the given interface of a container,
+// short enough to build host interface names from
+func ifSuffix(containerID, ifName string) string {
+	id := strings.Replace(containerID, "-", "", -1)
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return id + strings.TrimPrefix(ifName, "eth")
+}
+
+// ensureBridge creates the named bridge unless it already exists, and assigns
+// it the gateway address
+func ensureBridge(name string, gw net.IP, prefixLen int) error {
+	if err := plugin.IP("link", "show", name); err != nil {
+		if err := plugin.IP("link", "add", "name", name, "type", "bridge"); err != nil {
+			return err
+		}
+	}
+	if gw != nil {
+		if err := plugin.IP("addr", "replace", fmt.Sprintf("%v/%d", gw, prefixLen), "dev", name); err != nil {
+			return err
+		}
+	}
+	return plugin.IP("link", "set", name, "up")
+}
+
+// masqUsersOf returns the users of masquerading recorded in the data
+// directory of req
+func masqUsersOf(req *plugin.Request) (*masqUsers, error) {
+	return newMasqUsers(filepath.Join(req.DataDir, "bridge", "masq"))
+}
+
+// masqUser identifies the interface of the container of req
+func masqUser(req *plugin.Request) string {
+	return req.ContainerID + "-" + req.IfName
+}
+
+func add(req *plugin.Request) (*plugin.Result, error) {
+	conf, err := loadNetConf(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := plugin.ExecIPAM(req)
+	if err != nil {
+		return nil, err
+	}
+	res.HostIf = "veth" + ifSuffix(req.ContainerID, req.IfName)
+
+	if err := ensureBridge(conf.Bridge, res.Gateway, res.PrefixLen); err != nil {
+		return nil, fmt.Errorf("error setting up bridge %q: %v", conf.Bridge, err)
+	}
+	if conf.IPMasq {
+		mu, err := masqUsersOf(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := mu.add(masqSubnet(res.IP, res.PrefixLen), masqUser(req), ensureMasq); err != nil {
+			return nil, err
+		}
+	}
+
+	// the container end is created on the host, so needs a unique name
+	// until it is renamed inside the namespace
+	tmpIfName := "tmp" + ifSuffix(req.ContainerID, req.IfName)
+	if err := plugin.IP("link", "add", res.HostIf, "type", "veth", "peer", "name", tmpIfName); err != nil {
+		return nil, fmt.Errorf("error creating veth pair: %v", err)
+	}
+	if err := plugin.IP("link", "set", tmpIfName, "netns", req.NetNS); err != nil {
+		return nil, fmt.Errorf("error moving veth into network namespace: %v", err)
+	}
+	if err := plugin.IPNetNS(req.NetNS, "link", "set", tmpIfName, "name", req.IfName); err != nil {
+		return nil, err
+	}
+	if err := plugin.IP("link", "set", res.HostIf, "master", conf.Bridge); err != nil {
+		return nil, fmt.Errorf("error attaching veth to bridge: %v", err)
+	}
+	if err := plugin.IP("link", "set", res.HostIf, "up"); err != nil {
+		return nil, err
+	}
+
+	if err := plugin.ConfigureIface(req.NetNS, req.IfName, res); err != nil {
+		return nil, fmt.Errorf("error configuring %s: %v", req.IfName, err)
+	}
+
+	return res, nil
+}
+
+func del(req *plugin.Request) error {
+	// deleting the host end of the veth pair removes both ends, which are
+	// already gone if the namespace has been destroyed
+	plugin.IP("link", "del", "veth"+ifSuffix(req.ContainerID, req.IfName))
+
+	// the masquerading of a subnet goes along with its last container,
+	// which releases its address anyway
+	mu, err := masqUsersOf(req)
+	if err == nil {
+		err = mu.remove(masqUser(req), removeMasq)
+	}
+	if _, ierr := plugin.ExecIPAM(req); ierr != nil {
+		return ierr
+	}
+	return err
+}
+
+func main() {
+	plugin.Serve(add, del)
+}
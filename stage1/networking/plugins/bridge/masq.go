@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+// masqUsers records which container interfaces rely on the masquerading of
+// each subnet, as a file named after the interface in a directory named after
+// the subnet, so that the rule of a subnet is removed along with its last
+// user.
+type masqUsers struct {
+	dir string
+}
+
+func newMasqUsers(dir string) (*masqUsers, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating masquerading directory: %v", err)
+	}
+	return &masqUsers{dir: dir}, nil
+}
+
+// masqSubnet returns the subnet of ip, in CIDR notation
+func masqSubnet(ip net.IP, prefixLen int) string {
+	return fmt.Sprintf("%v/%d", ip.Mask(net.CIDRMask(prefixLen, 8*net.IPv4len)), prefixLen)
+}
+
+func (m *masqUsers) subnetDir(subnet string) string {
+	return filepath.Join(m.dir, strings.Replace(subnet, "/", "_", -1))
+}
+
+// add records user as relying on the masquerading of subnet, and sets it up
+// with setup
+func (m *masqUsers) add(subnet, user string, setup func(subnet string) error) error {
+	l, err := lock.ExclusiveLock(m.dir)
+	if err != nil {
+		return fmt.Errorf("error locking masquerading directory: %v", err)
+	}
+	defer l.Close()
+
+	sd := m.subnetDir(subnet)
+	if err := os.MkdirAll(sd, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(sd, user), nil, 0644); err != nil {
+		return err
+	}
+	return setup(subnet)
+}
+
+// remove forgets about user, and tears down with teardown the masquerading
+// of the subnets it was the last user of
+func (m *masqUsers) remove(user string, teardown func(subnet string) error) error {
+	l, err := lock.ExclusiveLock(m.dir)
+	if err != nil {
+		return fmt.Errorf("error locking masquerading directory: %v", err)
+	}
+	defer l.Close()
+
+	ls, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range ls {
+		if !fi.IsDir() {
+			continue
+		}
+		sd := filepath.Join(m.dir, fi.Name())
+		if err := os.Remove(filepath.Join(sd, user)); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		// fails as long as other users are left
+		if os.Remove(sd) != nil {
+			continue
+		}
+		if err := teardown(strings.Replace(fi.Name(), "_", "/", -1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// masqRule returns the iptables rule masquerading the traffic leaving subnet
+func masqRule(subnet string) []string {
+	return []string{"POSTROUTING", "-s", subnet, "!", "-d", subnet, "-j", "MASQUERADE"}
+}
+
+// ensureMasq masquerades traffic leaving subnet, unless this is already done
+func ensureMasq(subnet string) error {
+	rule := masqRule(subnet)
+	if exec.Command("iptables", append([]string{"-t", "nat", "-C"}, rule...)...).Run() == nil {
+		return nil
+	}
+	out, err := exec.Command("iptables", append([]string{"-t", "nat", "-A"}, rule...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error setting up masquerading: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removeMasq stops masquerading traffic leaving subnet, unless this is
+// already done
+func removeMasq(subnet string) error {
+	rule := masqRule(subnet)
+	if exec.Command("iptables", append([]string{"-t", "nat", "-C"}, rule...)...).Run() != nil {
+		return nil
+	}
+	out, err := exec.Command("iptables", append([]string{"-t", "nat", "-D"}, rule...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error removing masquerading: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
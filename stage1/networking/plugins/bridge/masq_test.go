@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMasqUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-masq")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mu, err := newMasqUsers(dir)
+	if err != nil {
+		t.Fatalf("error creating masquerading users: %v", err)
+	}
+	var set, unset []string
+	setup := func(subnet string) error {
+		set = append(set, subnet)
+		return nil
+	}
+	teardown := func(subnet string) error {
+		unset = append(unset, subnet)
+		return nil
+	}
+
+	for _, u := range []struct{ subnet, user string }{
+		{"10.1.2.0/24", "c1-eth0"},
+		{"10.1.2.0/24", "c2-eth0"},
+		{"10.1.3.0/24", "c1-eth1"},
+	} {
+		if err := mu.add(u.subnet, u.user, setup); err != nil {
+			t.Fatalf("error adding %s: %v", u.user, err)
+		}
+	}
+	if want := []string{"10.1.2.0/24", "10.1.2.0/24", "10.1.3.0/24"}; !reflect.DeepEqual(set, want) {
+		t.Errorf("set up %v, wanted %v", set, want)
+	}
+
+	// only the last user of a subnet tears it down
+	for _, step := range []struct {
+		user string
+		want []string
+	}{
+		{"c1-eth0", nil},
+		{"c1-eth1", []string{"10.1.3.0/24"}},
+		{"unknown", []string{"10.1.3.0/24"}},
+		{"c2-eth0", []string{"10.1.3.0/24", "10.1.2.0/24"}},
+		{"c2-eth0", []string{"10.1.3.0/24", "10.1.2.0/24"}},
+	} {
+		if err := mu.remove(step.user, teardown); err != nil {
+			t.Fatalf("error removing %s: %v", step.user, err)
+		}
+		if !reflect.DeepEqual(unset, step.want) {
+			t.Errorf("after removing %s: torn down %v, wanted %v", step.user, unset, step.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// host-local is an IPAM plugin allocating addresses from a subnet, keeping
+// track of the leases in files on the local host.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/coreos/rocket/stage1/networking/plugin"
+)
+
+type netConf struct {
+	Name string `json:"name"`
+	IPAM struct {
+		Subnet  string `json:"subnet"`
+		Gateway net.IP `json:"gateway"`
+	} `json:"ipam"`
+}
+
+func loadIPAM(req *plugin.Request) (*ipam, error) {
+	conf := &netConf{}
+	if err := json.Unmarshal(req.Net, conf); err != nil {
+		return nil, fmt.Errorf("error parsing network configuration: %v", err)
+	}
+	_, subnet, err := net.ParseCIDR(conf.IPAM.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing subnet %q: %v", conf.IPAM.Subnet, err)
+	}
+	return newIPAM(filepath.Join(req.DataDir, "ipam", conf.Name), subnet, conf.IPAM.Gateway)
+}
+
+func add(req *plugin.Request) (*plugin.Result, error) {
+	am, err := loadIPAM(req)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := am.allocate(req.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := am.subnet.Mask.Size()
+	return &plugin.Result{
+		IP:        ip,
+		PrefixLen: ones,
+		Gateway:   am.gateway,
+	}, nil
+}
+
+func del(req *plugin.Request) error {
+	am, err := loadIPAM(req)
+	if err != nil {
+		return err
+	}
+	return am.release(req.ContainerID)
+}
+
+func main() {
+	plugin.Serve(add, del)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+// ipam is a host-local IP address manager. Every allocated address is
+// recorded as a file named after the address in dir, containing the ID of the
+// container holding it.
+type ipam struct {
+	dir     string
+	subnet  *net.IPNet
+	gateway net.IP // never allocated
+}
+
+// newIPAM returns an ipam allocating from subnet, with leases recorded in dir.
+// If gateway is nil, the first address of the subnet is reserved for it.
+func newIPAM(dir string, subnet *net.IPNet, gateway net.IP) (*ipam, error) {
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("subnet %v is not an IPv4 subnet", subnet)
+	}
+	if gateway == nil {
+		gateway = addIP(subnet.IP.Mask(subnet.Mask), 1)
+	}
+	if !subnet.Contains(gateway) {
+		return nil, fmt.Errorf("gateway %v is outside of subnet %v", gateway, subnet)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating IPAM directory: %v", err)
+	}
+	return &ipam{dir: dir, subnet: subnet, gateway: gateway}, nil
+}
+
+// allocate reserves a free address of the subnet for the given container ID
+func (i *ipam) allocate(id string) (net.IP, error) {
+	l, err := lock.ExclusiveLock(i.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error locking IPAM directory: %v", err)
+	}
+	defer l.Close()
+
+	ones, bits := i.subnet.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	network := i.subnet.IP.Mask(i.subnet.Mask)
+
+	// skip the network and the broadcast address
+	for n := uint32(1); n < size-1; n++ {
+		ip := addIP(network, n)
+		if ip.Equal(i.gateway) {
+			continue
+		}
+		f, err := os.OpenFile(i.leasePath(ip), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		switch {
+		case err == nil:
+		case os.IsExist(err):
+			continue
+		default:
+			return nil, err
+		}
+		_, err = f.WriteString(id)
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+			return nil, err
+		}
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("no free addresses left in %v", i.subnet)
+}
+
+// release frees the addresses held by the given container ID
+func (i *ipam) release(id string) error {
+	l, err := lock.ExclusiveLock(i.dir)
+	if err != nil {
+		return fmt.Errorf("error locking IPAM directory: %v", err)
+	}
+	defer l.Close()
+
+	ls, err := ioutil.ReadDir(i.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range ls {
+		if net.ParseIP(fi.Name()) == nil {
+			continue
+		}
+		lp := filepath.Join(i.dir, fi.Name())
+		holder, err := ioutil.ReadFile(lp)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(holder)) != id {
+			continue
+		}
+		if err := os.Remove(lp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *ipam) leasePath(ip net.IP) string {
+	return filepath.Join(i.dir, ip.String())
+}
+
+// addIP returns the IPv4 address n addresses after ip
+func addIP(ip net.IP, n uint32) net.IP {
+	res := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(res, binary.BigEndian.Uint32(ip.To4())+n)
+	return res
+}
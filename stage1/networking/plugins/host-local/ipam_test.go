@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestIPAM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-ipam")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, subnet, _ := net.ParseCIDR("10.1.2.0/29")
+	am, err := newIPAM(dir, subnet, nil)
+	if err != nil {
+		t.Fatalf("error creating IPAM: %v", err)
+	}
+
+	if gw := am.gateway; !gw.Equal(net.ParseIP("10.1.2.1")) {
+		t.Errorf("unexpected gateway: %v", gw)
+	}
+
+	// a /29 leaves five addresses once the gateway is reserved
+	var ips []net.IP
+	for i := 0; i < 5; i++ {
+		ip, err := am.allocate("c1")
+		if err != nil {
+			t.Fatalf("error allocating IP: %v", err)
+		}
+		ips = append(ips, ip)
+	}
+	if !ips[0].Equal(net.ParseIP("10.1.2.2")) || !ips[4].Equal(net.ParseIP("10.1.2.6")) {
+		t.Errorf("unexpected allocations: %v", ips)
+	}
+	if _, err := am.allocate("c2"); err == nil {
+		t.Fatalf("expected error allocating from an exhausted subnet")
+	}
+
+	// releasing a container holding no address is a no-op
+	if err := am.release("c2"); err != nil {
+		t.Fatalf("error releasing IP: %v", err)
+	}
+	if _, err := am.allocate("c2"); err == nil {
+		t.Fatalf("expected error allocating from an exhausted subnet")
+	}
+
+	if err := am.release("c1"); err != nil {
+		t.Fatalf("error releasing IP: %v", err)
+	}
+	ip, err := am.allocate("c2")
+	if err != nil {
+		t.Fatalf("error allocating IP: %v", err)
+	}
+	if !ip.Equal(ips[0]) {
+		t.Errorf("expected released IP %v to be reallocated, got %v", ips[0], ip)
+	}
+}
+
+func TestIPAMGateway(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-ipam")
+	if err != nil {
+		t.Fatalf("error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, subnet, _ := net.ParseCIDR("10.1.2.0/24")
+	if _, err := newIPAM(dir, subnet, net.ParseIP("10.1.3.1")); err == nil {
+		t.Fatalf("expected error using a gateway outside of the subnet")
+	}
+
+	am, err := newIPAM(dir, subnet, net.ParseIP("10.1.2.2"))
+	if err != nil {
+		t.Fatalf("error creating IPAM: %v", err)
+	}
+	for _, want := range []string{"10.1.2.1", "10.1.2.3"} {
+		ip, err := am.allocate("c1")
+		if err != nil {
+			t.Fatalf("error allocating IP: %v", err)
+		}
+		if !ip.Equal(net.ParseIP(want)) {
+			t.Errorf("unexpected allocation: got %v, want %v", ip, want)
+		}
+	}
+}
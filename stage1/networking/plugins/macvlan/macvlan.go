@@ -0,0 +1,84 @@
+// macvlan is a network plugin giving containers a macvlan interface on top of
+// a host interface, so that they appear directly on the host's network.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/rocket/stage1/networking/plugin"
+)
+
+const defaultMode = "bridge"
+
+type netConf struct {
+	plugin.NetConf
+	Master string `json:"master"`
+	Mode   string `json:"mode"`
+}
+
+func loadNetConf(req *plugin.Request) (*netConf, error) {
+	conf := &netConf{Mode: defaultMode}
+	if err := json.Unmarshal(req.Net, conf); err != nil {
+		return nil, fmt.Errorf("error parsing network configuration: %v", err)
+	}
+	if conf.Master == "" {
+		return nil, fmt.Errorf(`network %q: "master" interface is required`, conf.Name)
+	}
+	switch conf.Mode {
+	case "private", "vepa", "bridge", "passthru":
+	default:
+		return nil, fmt.Errorf("network %q: unknown macvlan mode %q", conf.Name, conf.Mode)
+	}
+	return conf, nil
+}
+
+func add(req *plugin.Request) (*plugin.Result, error) {
+	conf, err := loadNetConf(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := plugin.ExecIPAM(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the interface is created on the host, so needs a unique name until it
+	// is renamed inside the namespace
+	id := strings.Replace(req.ContainerID, "-", "", -1)
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	tmpIfName := "mv" + id + strings.TrimPrefix(req.IfName, "eth")
+
+	if err := plugin.IP("link", "add", "link", conf.Master, "name", tmpIfName, "type", "macvlan", "mode", conf.Mode); err != nil {
+		return nil, fmt.Errorf("error creating macvlan interface: %v", err)
+	}
+	if err := plugin.IP("link", "set", tmpIfName, "netns", req.NetNS); err != nil {
+		plugin.IP("link", "del", tmpIfName)
+		return nil, fmt.Errorf("error moving macvlan interface into network namespace: %v", err)
+	}
+	if err := plugin.IPNetNS(req.NetNS, "link", "set", tmpIfName, "name", req.IfName); err != nil {
+		return nil, err
+	}
+
+	if err := plugin.ConfigureIface(req.NetNS, req.IfName, res); err != nil {
+		return nil, fmt.Errorf("error configuring %s: %v", req.IfName, err)
+	}
+
+	return res, nil
+}
+
+func del(req *plugin.Request) error {
+	// the interface is gone already if the namespace has been destroyed
+	plugin.IPNetNS(req.NetNS, "link", "del", req.IfName)
+
+	_, err := plugin.ExecIPAM(req)
+	return err
+}
+
+func main() {
+	plugin.Serve(add, del)
+}
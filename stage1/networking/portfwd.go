@@ -0,0 +1,258 @@
+package networking
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+// ForwardedPort requests a port of an app, named as in its image manifest, to
+// be published on the host
+type ForwardedPort struct {
+	Name     types.ACName
+	HostPort uint
+}
+
+func (fp ForwardedPort) String() string {
+	return fmt.Sprintf("%s:%d", fp.Name, fp.HostPort)
+}
+
+// ForwardedPorts implements the flag.Value interface to contain a list of
+// ports in the form name:hostPort
+type ForwardedPorts []ForwardedPort
+
+func (fps *ForwardedPorts) Set(s string) error {
+	elems := strings.Split(s, ":")
+	if len(elems) != 2 {
+		return errors.New("port must be of form name:hostPort")
+	}
+	name, err := types.NewACName(elems[0])
+	if err != nil {
+		return fmt.Errorf("invalid port name %q: %v", elems[0], err)
+	}
+	hp, err := strconv.ParseUint(elems[1], 10, 16)
+	if err != nil || hp == 0 {
+		return fmt.Errorf("invalid host port %q", elems[1])
+	}
+	for _, fp := range *fps {
+		if fp.HostPort == uint(hp) {
+			return fmt.Errorf("host port %d forwarded more than once", hp)
+		}
+	}
+	*fps = append(*fps, ForwardedPort{Name: *name, HostPort: uint(hp)})
+	return nil
+}
+
+func (fps *ForwardedPorts) String() string {
+	var ss []string
+	for _, fp := range *fps {
+		ss = append(ss, fp.String())
+	}
+	return strings.Join(ss, ",")
+}
+
+// PortFwd forwards a port of the host to a port of the container
+type PortFwd struct {
+	Name     types.ACName `json:"name"`     // name of the port in the image manifest
+	Protocol string       `json:"protocol"` // tcp or udp
+	HostPort uint         `json:"hostPort"`
+	Port     uint         `json:"port"` // port in the container
+}
+
+// ResolvePorts looks the requested ports up in the image manifests of the
+// container's apps
+func ResolvePorts(fps ForwardedPorts, ams []*schema.ImageManifest) ([]PortFwd, error) {
+	var pfs []PortFwd
+	for _, fp := range fps {
+		var found *types.Port
+		for _, am := range ams {
+			for i, p := range am.App.Ports {
+				if !p.Name.Equals(fp.Name) {
+					continue
+				}
+				if found != nil && (found.Protocol != p.Protocol || found.Port != p.Port) {
+					return nil, fmt.Errorf("port %q is declared differently by several apps", fp.Name)
+				}
+				found = &am.App.Ports[i]
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("port %q is not declared by any app", fp.Name)
+		}
+		switch found.Protocol {
+		case "tcp", "udp":
+		default:
+			return nil, fmt.Errorf("port %q: unsupported protocol %q", fp.Name, found.Protocol)
+		}
+		pfs = append(pfs, PortFwd{
+			Name:     fp.Name,
+			Protocol: found.Protocol,
+			HostPort: fp.HostPort,
+			Port:     found.Port,
+		})
+	}
+	return pfs, nil
+}
+
+// ForwardPorts forwards the given ports of the host to the container's
+// address on its first network. The forwarding is recorded along with the
+// network, to be undone by UnforwardPorts or Teardown.
+func (n *Networking) ForwardPorts(root string, pfs []PortFwd) error {
+	if len(pfs) == 0 {
+		return nil
+	}
+	if len(n.Nets) == 0 {
+		return errors.New("forwarding ports requires a private network")
+	}
+	ni := n.Nets[0]
+
+	for _, pf := range pfs {
+		if err := checkHostPort(ni.IP, pf); err != nil {
+			return err
+		}
+	}
+
+	// record the rules first so a partial setup can be undone
+	ni.Ports = pfs
+	if err := saveNetInfo(root, ni); err != nil {
+		return err
+	}
+	for _, pf := range pfs {
+		for _, r := range portFwdRules(ni.IP, pf) {
+			if err := appendRule(r); err != nil {
+				return fmt.Errorf("error forwarding port %q: %v", pf.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// UnforwardPorts removes the port forwarding of the container rooted at root
+func UnforwardPorts(root string) error {
+	nis, err := LoadNetInfo(root)
+	if err != nil {
+		return err
+	}
+	for _, ni := range nis {
+		if len(ni.Ports) == 0 {
+			continue
+		}
+		if err := unforwardPorts(ni); err != nil {
+			return err
+		}
+		ni.Ports = nil
+		if err := saveNetInfo(root, ni); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unforwardPorts(ni *NetInfo) error {
+	for _, pf := range ni.Ports {
+		for _, r := range portFwdRules(ni.IP, pf) {
+			if err := deleteRule(r); err != nil {
+				return fmt.Errorf("error removing forwarding of port %q: %v", pf.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// portFwdRules returns the iptables rules forwarding pf to ip, each starting
+// with the table and chain
+func portFwdRules(ip net.IP, pf PortFwd) [][]string {
+	hp := strconv.FormatUint(uint64(pf.HostPort), 10)
+	p := strconv.FormatUint(uint64(pf.Port), 10)
+	dst := net.JoinHostPort(ip.String(), p)
+	return [][]string{
+		// connections from the outside
+		{"nat", "PREROUTING", "-p", pf.Protocol, "-m", "addrtype", "--dst-type", "LOCAL",
+			"--dport", hp, "-j", "DNAT", "--to-destination", dst},
+		// connections from the host itself
+		{"nat", "OUTPUT", "-p", pf.Protocol, "-m", "addrtype", "--dst-type", "LOCAL", "!", "-d", "127.0.0.0/8",
+			"--dport", hp, "-j", "DNAT", "--to-destination", dst},
+		{"filter", "FORWARD", "-p", pf.Protocol, "-d", ip.String(), "--dport", p, "-j", "ACCEPT"},
+	}
+}
+
+// checkHostPort fails if the host port of pf is already forwarded somewhere
+// else than ip, as the rule appended for ip would then never match
+func checkHostPort(ip net.IP, pf PortFwd) error {
+	out, err := exec.Command("iptables", "-t", "nat", "-S", "PREROUTING").Output()
+	if err != nil {
+		return fmt.Errorf("error listing forwarded ports: %v", err)
+	}
+	if dst := dnatDestination(string(out), pf.Protocol, pf.HostPort); dst != "" {
+		p := strconv.FormatUint(uint64(pf.Port), 10)
+		if dst != net.JoinHostPort(ip.String(), p) {
+			return fmt.Errorf("host port %d/%s is already forwarded to %s", pf.HostPort, pf.Protocol, dst)
+		}
+	}
+	return nil
+}
+
+// dnatDestination returns the destination of the first DNAT rule of rules,
+// as listed by iptables -S, matching the protocol and destination port, or
+// "" if there is none
+func dnatDestination(rules string, proto string, port uint) string {
+	dport := strconv.FormatUint(uint64(port), 10)
+	for _, l := range strings.Split(rules, "\n") {
+		var p, dp, target, dst string
+		f := strings.Fields(l)
+		for i := 0; i+1 < len(f); i++ {
+			switch f[i] {
+			case "-p":
+				p = f[i+1]
+			case "--dport":
+				dp = f[i+1]
+			case "-j":
+				target = f[i+1]
+			case "--to-destination":
+				dst = f[i+1]
+			default:
+				continue
+			}
+			i++
+		}
+		if target == "DNAT" && p == proto && dp == dport {
+			return dst
+		}
+	}
+	return ""
+}
+
+func ruleExists(r []string) bool {
+	return exec.Command("iptables", append([]string{"-t", r[0], "-C", r[1]}, r[2:]...)...).Run() == nil
+}
+
+// appendRule appends r unless it already exists
+func appendRule(r []string) error {
+	if ruleExists(r) {
+		return nil
+	}
+	return iptables(append([]string{"-t", r[0], "-A", r[1]}, r[2:]...)...)
+}
+
+// deleteRule deletes r unless it is already gone
+func deleteRule(r []string) error {
+	if !ruleExists(r) {
+		return nil
+	}
+	return iptables(append([]string{"-t", r[0], "-D", r[1]}, r[2:]...)...)
+}
+
+func iptables(args ...string) error {
+	out, err := exec.Command("iptables", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iptables %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package networking
+
+import (
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestForwardedPortsSet(t *testing.T) {
+	var fps ForwardedPorts
+	for _, s := range []string{"http:8080", "dns:53"} {
+		if err := fps.Set(s); err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", s, err)
+		}
+	}
+	if got := fps.String(); got != "http:8080,dns:53" {
+		t.Errorf("unexpected ports: %q", got)
+	}
+
+	for _, s := range []string{"http", "http:", "http:0", "http:65536", "http:80:80", "other:8080"} {
+		if err := fps.Set(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestResolvePorts(t *testing.T) {
+	am := func(ports ...types.Port) *schema.ImageManifest {
+		return &schema.ImageManifest{App: types.App{Ports: ports}}
+	}
+	ams := []*schema.ImageManifest{
+		am(types.Port{Name: "http", Protocol: "tcp", Port: 80}),
+		am(types.Port{Name: "dns", Protocol: "udp", Port: 53}, types.Port{Name: "http", Protocol: "tcp", Port: 80}),
+		am(types.Port{Name: "sctp", Protocol: "sctp", Port: 9}),
+	}
+
+	pfs, err := ResolvePorts(ForwardedPorts{{"http", 8080}, {"dns", 5353}}, ams)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []PortFwd{
+		{Name: "http", Protocol: "tcp", HostPort: 8080, Port: 80},
+		{Name: "dns", Protocol: "udp", HostPort: 5353, Port: 53},
+	}
+	if len(pfs) != len(want) {
+		t.Fatalf("unexpected number of ports: %d, wanted %d", len(pfs), len(want))
+	}
+	for i := range want {
+		if pfs[i] != want[i] {
+			t.Errorf("port %d: got %+v, wanted %+v", i, pfs[i], want[i])
+		}
+	}
+
+	for _, fps := range []ForwardedPorts{{{"missing", 8080}}, {{"sctp", 9}}} {
+		if _, err := ResolvePorts(fps, ams); err == nil {
+			t.Errorf("expected error resolving %v", fps)
+		}
+	}
+
+	ams = append(ams, am(types.Port{Name: "http", Protocol: "tcp", Port: 8000}))
+	if _, err := ResolvePorts(ForwardedPorts{{"http", 8080}}, ams); err == nil {
+		t.Errorf("expected error resolving port declared differently")
+	}
+}
+
+func TestDNATDestination(t *testing.T) {
+	rules := `-P PREROUTING ACCEPT
+-A PREROUTING -m addrtype --dst-type LOCAL -j DOCKER
+-A PREROUTING -p tcp -m addrtype --dst-type LOCAL -m tcp --dport 8080 -j DNAT --to-destination 172.16.28.2:80
+-A PREROUTING -p udp -m addrtype --dst-type LOCAL -m udp --dport 5353 -j DNAT --to-destination 172.16.28.3:53
+`
+	for i, tt := range []struct {
+		proto string
+		port  uint
+		want  string
+	}{
+		{"tcp", 8080, "172.16.28.2:80"},
+		{"udp", 5353, "172.16.28.3:53"},
+		{"udp", 8080, ""},
+		{"tcp", 80, ""},
+	} {
+		if got := dnatDestination(rules, tt.proto, tt.port); got != tt.want {
+			t.Errorf("#%d: got %q, wanted %q", i, got, tt.want)
+		}
+	}
+}